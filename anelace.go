@@ -1,6 +1,7 @@
 package anelace
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/anjor/anelace/internal/block"
 	"github.com/anjor/anelace/internal/chunker"
@@ -11,17 +12,23 @@ import (
 	"github.com/anjor/anelace/internal/collector/fixedcidrefsize"
 	"github.com/anjor/anelace/internal/collector/fixedoutdegree"
 	"github.com/anjor/anelace/internal/collector/noop"
+	"github.com/anjor/anelace/internal/collector/prolly"
 	"github.com/anjor/anelace/internal/collector/trickle"
 	"github.com/anjor/anelace/internal/constants"
 	"github.com/anjor/anelace/internal/encoder"
 	"github.com/anjor/anelace/internal/encoder/unixfsv1"
+	"github.com/anjor/anelace/internal/transform"
+	"github.com/anjor/anelace/internal/transform/convergent"
 	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/decompress"
 	"github.com/anjor/anelace/internal/util/text"
 	"github.com/pborman/getopt/v2"
 	"io"
+	"log"
 	"os"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ipfs/go-qringbuf"
 )
@@ -35,11 +42,15 @@ var availableCollectors = map[string]anlcollector.Initializer{
 	"none":                noop.NewCollector,
 	"fixed-cid-refs-size": fixedcidrefsize.NewCollector,
 	"fixed-outdegree":     fixedoutdegree.NewCollector,
+	"prolly":              prolly.NewCollector,
 	"trickle":             trickle.NewCollector,
 }
 var availableNodeEncoders = map[string]anlencoder.Initializer{
 	"unixfsv1": unixfsv1.NewEncoder,
 }
+var availableLeafTransforms = map[string]anltransform.Initializer{
+	"convergent": convergent.NewTransform,
+}
 
 type chunkerUnit struct {
 	_         constants.Incomparabe
@@ -50,7 +61,10 @@ type chunkerUnit struct {
 type carUnit struct {
 	_      constants.Incomparabe
 	hdr    *anlblock.Header
-	region *qringbuf.Region
+	region dataRegion
+
+	// when set, the writer only closes it once everything queued before is written
+	barrier chan struct{}
 }
 
 type seenBlocks map[[seenHashSize]byte]uniqueBlockStats
@@ -60,24 +74,43 @@ type Anelace struct {
 	// speederization shortcut flags for internal logic
 	generateRoots bool
 
-	curStreamOffset  int64
-	cfg              config
-	statSummary      statSummary
-	chunker          chunkerUnit
-	collector        anlcollector.Collector
-	formattedCid     func(*anlblock.Header) string
-	externalEventBus chan<- IngestionEvent
-	qrb              *qringbuf.QuantizedRingBuffer
-	asyncWG          sync.WaitGroup
-	asyncHashingBus  anlblock.AsyncHashingBus
-	mu               sync.Mutex
-	seenBlocks       seenBlocks
-	seenRoots        seenRoots
-	carDataQueue     chan carUnit
-	carWriteError    chan error
-	carDataWriter    io.Writer
-	stderrWriter     io.Writer
-	stdoutWriter     io.Writer
+	curStreamOffset   int64
+	cfg               config
+	statSummary       statSummary
+	chunker           chunkerUnit
+	collector         anlcollector.Collector
+	leafTransform     anltransform.Transform
+	rootDecorator     anlencoder.RootDecorator
+	symlinkEncoder    anlencoder.SymlinkEncoder
+	heldBlock         postProcessTask // the root candidate, when rootDecorator is set
+	formattedCid      func(*anlblock.Header) string
+	externalEventBus  chan<- IngestionEvent
+	qrb               *qringbuf.QuantizedRingBuffer
+	asyncWG           sync.WaitGroup
+	postProcessQueue  chan postProcessTask
+	asyncHashingBus   *anlblock.AsyncHashingBus
+	mu                sync.Mutex
+	seenBlocks        seenBlocks
+	seenRoots         seenRoots
+	carDataQueue      chan carUnit
+	carWriteError     chan error
+	carDataWriter     io.Writer
+	carOffset         int64 // owned by the car writer
+	multipartFrames   int64
+	multipartOffset   int64
+	curSubstream      substreamHeader
+	resumeFrom        *checkpoint
+	mappedInput       []byte
+	mappedReader      *bytes.Reader
+	inputFiles        []inputFile
+	metrics           *ingestMetrics
+	progressOut       io.Writer
+	progressInterval  time.Duration
+	expectedInputSize int64
+	inputBytesRead    int64 // atomic
+	blockEvents       bool
+	stderrWriter      io.Writer
+	stdoutWriter      io.Writer
 }
 
 func NewAnelace() *Anelace {
@@ -143,20 +176,123 @@ func NewAnelaceWithWriters(stderr io.Writer, stdout io.Writer) (*Anelace, []erro
 
 func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 
-	anl = NewAnelace()
+	anl, argParseErrs := NewAnelaceFromArgvWithWriters(argv, os.Stderr, os.Stdout)
+	logArgParseErrors(argParseErrs, &anl.cfg)
+
+	if anl.cfg.DumpConfig {
+		if err := dumpConfig(anl.stdoutWriter, anl.statSummary.SysStats.ArgvExpanded); err != nil {
+			log.Fatalf("unexpected error dumping config: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	return anl
+}
+
+// NewAnelaceFromArgvWithWriters is the non-exiting variant of
+// NewAnelaceFromArgv(), with emitters bound to the supplied writers. The
+// argParseErrs must be checked before using the returned object, which in
+// turn must be Destroy()ed regardless.
+func NewAnelaceFromArgvWithWriters(argv []string, stderr io.Writer, stdout io.Writer) (anl *Anelace, argParseErrs []error) {
+
+	// do not go through NewAnelace(): everything below must be set up exactly once
+	anl = &Anelace{
+		cfg:          defaultConfig(),
+		statSummary:  setStatSummary(),
+		stderrWriter: stderr,
+		stdoutWriter: stdout,
+	}
 	anl.statSummary.SysStats.ArgvInitial = getInitialArgs(argv)
 
 	cfg := &anl.cfg
 	cfg.initArgvParser()
 
 	// accumulator for multiple errors, to present to the user all at once
-	argParseErrs := argparser.Parse(argv, cfg.optSet)
+	cfg.inputPaths, argParseErrs = argparser.ParseWithParameters(argv, cfg.optSet)
+
+	// a preset, config file or replayed summary are re-parsed ahead of the
+	// actual argv, so they are subject to the exact same validation
+	if len(argParseErrs) == 0 && (cfg.ConfigFile != "" || cfg.preset != "" || cfg.ReplayFrom != "") {
+		var baseArgs, replayRoots []string
+		var err error
+		if cfg.ReplayFrom != "" {
+			baseArgs, replayRoots, err = cfg.replayArgs()
+		} else {
+			baseArgs, err = cfg.baseArgs()
+		}
+
+		if err != nil {
+			argParseErrs = append(argParseErrs, err)
+		} else {
+			anl.cfg = defaultConfig()
+			cfg.initArgvParser()
+			cfg.replayRoots = replayRoots
+			cfg.inputPaths, argParseErrs = argparser.ParseWithParameters(
+				append(append([]string{argv[0]}, baseArgs...), argv[1:]...),
+				cfg.optSet,
+			)
+		}
+	}
+
+	if cfg.ReplayVerify {
+		if cfg.ReplayFrom == "" {
+			argParseErrs = append(argParseErrs, fmt.Errorf("--replay-verify requires --replay-from"))
+		}
+		// roots are only recorded when block stats are active
+		cfg.StatsActive |= statsBlocks
+	}
+
+	// everything else applies to both framings
+	if cfg.MultipartTLV {
+		cfg.MultipartStream = true
+	}
+
+	if cfg.FilesFrom != "" {
+		if paths, err := readFilesFrom(cfg.FilesFrom); err != nil {
+			argParseErrs = append(argParseErrs, err)
+		} else {
+			cfg.inputPaths = append(cfg.inputPaths, paths...)
+		}
+	}
+	if len(cfg.inputPaths) > 0 {
+		if cfg.MultipartStream {
+			argParseErrs = append(argParseErrs, fmt.Errorf("--multipart can not be combined with input files, each of which is a substream already"))
+		}
+		if cfg.inputDecompress != "none" {
+			argParseErrs = append(argParseErrs, fmt.Errorf("--input-decompress can not be combined with input files"))
+		}
+		var errs []error
+		anl.inputFiles, errs = statInputFiles(cfg.inputPaths, cfg.Symlinks == "preserve")
+		argParseErrs = append(argParseErrs, errs...)
+	}
+
+	if cfg.Symlinks != "follow" && cfg.Symlinks != "preserve" {
+		argParseErrs = append(argParseErrs, fmt.Errorf("--symlinks '%s' is not one of 'follow', 'preserve'", cfg.Symlinks))
+	}
+
+	if (cfg.Checkpoint != "" || cfg.Resume != "") && !cfg.MultipartStream && len(cfg.inputPaths) == 0 {
+		argParseErrs = append(argParseErrs, fmt.Errorf("--checkpoint and --resume require --multipart or input files"))
+	}
+	if cfg.CheckpointInterval < 0 {
+		argParseErrs = append(argParseErrs, fmt.Errorf("--checkpoint-interval can not be negative"))
+	}
 
 	if cfg.Help || cfg.HelpAll {
 		cfg.printUsage()
 		os.Exit(0)
 	}
 
+	if cfg.GenerateRabinPoly || cfg.GenerateBuzhashTable {
+		if err := printGenerated(anl.stdoutWriter, cfg); err != nil {
+			log.Fatalf("unexpected error generating chunker parameters: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	if cfg.preset != "" && cfg.optSet.IsSet("ipfs-add-compatible-command") {
+		argParseErrs = append(argParseErrs, fmt.Errorf("--preset and --ipfs-add-compatible-command are mutually exclusive"))
+	}
+
 	// pre-populate from a compat `ipfs add` command if one was supplied
 	if cfg.optSet.IsSet("ipfs-add-compatible-command") {
 		if errStrings := cfg.presetFromIPFS(); len(errStrings) > 0 {
@@ -169,6 +305,18 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 		argParseErrs = append(argParseErrs, fmt.Errorf("The value of --hash-bits must be a minimum of 128 and be divisible by 8"))
 	}
 
+	if !decompress.IsValidFormat(cfg.inputDecompress) {
+		argParseErrs = append(argParseErrs,
+			fmt.Errorf("--input-decompress '%s' is not one of %s",
+				cfg.inputDecompress,
+				decompress.AvailableFormats(),
+			))
+	}
+
+	if cfg.InputMmap && cfg.inputDecompress != "none" {
+		argParseErrs = append(argParseErrs, fmt.Errorf("--input-mmap can not be combined with --input-decompress"))
+	}
+
 	if !inlineMaxSizeWithinBounds(cfg.InlineMaxSize) {
 		argParseErrs = append(argParseErrs,
 			fmt.Errorf("--inline-max-size '%s' out of bounds 0 or [4:%d]",
@@ -177,6 +325,11 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 			))
 	}
 
+	// needs to exist before the block maker, which reports hashing times
+	if cfg.MetricsListen != "" {
+		anl.metrics = newIngestMetrics()
+	}
+
 	// Parses/creates the blockmaker/nodeencoder, to pass in turn to the collector chain
 	// Not stored in the anl object itself, to cut down on logic leaks
 	nodeEnc, errorMessages := anl.setupEncoding()
@@ -190,7 +343,13 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 		argParseErrs = append(argParseErrs, anl.setupCarWriting()...)
 	}
 
-	logArgParseErrors(argParseErrs, cfg)
+	if len(argParseErrs) == 0 && anl.metrics != nil {
+		argParseErrs = append(argParseErrs, anl.startMetricsListener()...)
+	}
+
+	if len(argParseErrs) > 0 {
+		return
+	}
 
 	// Opts *still* check out - take a snapshot of what we ended up with
 
@@ -200,6 +359,7 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 		"hash",
 		"hash-bits",
 		"chunker",
+		"leaf-transform",
 		"collector",
 		"node-encoder",
 	}
@@ -211,7 +371,7 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 	// first do the generic options
 	cfg.optSet.VisitAll(func(o getopt.Option) {
 		switch o.LongName() {
-		case "help", "help-all", "ipfs-add-compatible-command":
+		case "help", "help-all", "preset", "config", "dump-config", "replay-from", "replay-verify", "metrics-listen", "progress", "checkpoint", "checkpoint-interval", "resume", "files-from", "ipfs-add-compatible-command", "generate-rabin-polynomial", "generate-buzhash-table":
 			// do nothing for these
 		default:
 			// skip these keys too, they come next
@@ -229,6 +389,10 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 
 	// now do the remaining cid-determining options
 	for _, n := range cidOpts {
+		// an opt-in feature: not present unless requested
+		if n == "leaf-transform" && cfg.requestedTransform == "" {
+			continue
+		}
 		anl.statSummary.SysStats.ArgvExpanded = append(
 			anl.statSummary.SysStats.ArgvExpanded, fmt.Sprintf(`--%s=%s`,
 				n,
@@ -237,16 +401,23 @@ func NewAnelaceFromArgv(argv []string) (anl *Anelace) {
 		)
 	}
 
+	// needs the final argvExpanded to compare against
+	if cfg.Resume != "" {
+		argParseErrs = append(argParseErrs, anl.setupResume()...)
+	}
+
 	return
 }
 
 func (anl *Anelace) Destroy() {
 	anl.mu.Lock()
-	if anl.asyncHashingBus != nil {
-		close(anl.asyncHashingBus)
-		anl.asyncHashingBus = nil
-	}
+	anl.asyncHashingBus.Close()
+	anl.asyncHashingBus = nil
 	anl.qrb = nil
+	if anl.metrics != nil && anl.metrics.listener != nil {
+		anl.metrics.listener.Close() //nolint:errcheck
+		anl.metrics.listener = nil
+	}
 	anl.mu.Unlock()
 }
 
@@ -257,3 +428,13 @@ func (anl *Anelace) SetCarWriter(w io.Writer) {
 func (anl *Anelace) SetMultipart(m bool) {
 	anl.cfg.MultipartStream = m
 }
+
+// SetInputDecompress selects how the input of ProcessReader() is decompressed:
+// "none" (the default), "auto", or an explicit format
+func (anl *Anelace) SetInputDecompress(format string) error {
+	if !decompress.IsValidFormat(format) {
+		return fmt.Errorf("unknown decompression format '%s', available formats are: %s", format, decompress.AvailableFormats())
+	}
+	anl.cfg.inputDecompress = format
+	return nil
+}
@@ -1,12 +1,17 @@
 package anelace
 
 import (
-	"encoding/base32"
+	"crypto/rand"
 	"fmt"
 	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/chunker/buzhash"
+	"github.com/anjor/anelace/internal/chunker/rabin/bootstrap"
 	"github.com/anjor/anelace/internal/collector"
 	"github.com/anjor/anelace/internal/constants"
 	"github.com/anjor/anelace/internal/encoder"
+	"github.com/anjor/anelace/internal/transform"
+	"github.com/anjor/anelace/internal/util/decompress"
+	"github.com/anjor/anelace/internal/util/multibase"
 	"github.com/anjor/anelace/internal/util/stream"
 	"github.com/anjor/anelace/internal/util/text"
 	"io"
@@ -16,8 +21,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/multiformats/go-base36"
+	"time"
 
 	"github.com/pborman/getopt/v2"
 	"github.com/pborman/options"
@@ -43,13 +47,17 @@ var argParseErrOut = os.Stderr
 
 func (cfg *config) printUsage() {
 	cfg.optSet.PrintUsage(argParseErrOut)
-	if cfg.HelpAll || len(cfg.erroredChunkers) > 0 || len(cfg.erroredCollectors) > 0 {
+	if cfg.HelpAll || len(cfg.erroredChunkers) > 0 || len(cfg.erroredCollectors) > 0 || len(cfg.erroredTransforms) > 0 {
 		printPluginUsage(
 			argParseErrOut,
 			cfg.erroredCollectors,
 			cfg.erroredNodeEncoders,
 			cfg.erroredChunkers,
+			cfg.erroredTransforms,
 		)
+		if cfg.HelpAll {
+			printPresetUsage(argParseErrOut)
+		}
 	} else {
 		fmt.Fprint(argParseErrOut, "\nTry --help-all for more info\n\n")
 	}
@@ -60,10 +68,11 @@ func printPluginUsage(
 	listCollectors []string,
 	listNodeEncoders []string,
 	listChunkers []string,
+	listTransforms []string,
 ) {
 
 	// if nothing was requested explicitly - list everything
-	if len(listCollectors) == 0 && len(listNodeEncoders) == 0 && len(listChunkers) == 0 {
+	if len(listCollectors) == 0 && len(listNodeEncoders) == 0 && len(listChunkers) == 0 && len(listTransforms) == 0 {
 		for name, initializer := range availableCollectors {
 			if initializer != nil {
 				listCollectors = append(listCollectors, name)
@@ -79,6 +88,11 @@ func printPluginUsage(
 				listChunkers = append(listChunkers, name)
 			}
 		}
+		for name, initializer := range availableLeafTransforms {
+			if initializer != nil {
+				listTransforms = append(listTransforms, name)
+			}
+		}
 	}
 
 	if len(listCollectors) != 0 {
@@ -135,9 +149,45 @@ func printPluginUsage(
 		}
 	}
 
+	if len(listTransforms) != 0 {
+		fmt.Fprint(out, "\n")
+		sort.Strings(listTransforms)
+		for _, name := range listTransforms {
+			fmt.Fprintf(
+				out,
+				"[L]eafTransform '%s'\n",
+				name,
+			)
+			_, h := availableLeafTransforms[name](nil, nil)
+			if len(h) == 0 {
+				fmt.Fprint(out, "  -- no helptext available --\n\n")
+			} else {
+				fmt.Fprintln(out, strings.Join(getErrrStrings(h), "\n"))
+			}
+		}
+	}
+
 	fmt.Fprint(out, "\n")
 }
 
+func printGenerated(out io.Writer, cfg *config) error {
+	if cfg.GenerateRabinPoly {
+		pol, err := bootstrap.RandomIrreducible(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d\n", pol)
+	}
+	if cfg.GenerateBuzhashTable {
+		xv, err := buzhash.RandomTable(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, buzhash.FormatTable(xv))
+	}
+	return nil
+}
+
 func (cfg *config) initArgvParser() {
 	// The default documented way of using pborman/options is to muck with globals
 	// Operate over objects instead, allowing us to re-parse argv multiple times
@@ -147,9 +197,8 @@ func (cfg *config) initArgvParser() {
 	}
 	cfg.optSet = o
 
-	// program does not take freeform args
-	// need to override this for sensible help render
-	o.SetParameters("")
+	// the only freeform args are input files
+	o.SetParameters("[file ...]")
 
 	// Several options have the help-text assembled programmatically
 	o.FlagLong(&cfg.hashFunc, "hash", 0, "Hash function to use, one of: "+text.AvailableMapKeys(anlblock.AvailableHashers),
@@ -162,10 +211,24 @@ func (cfg *config) initArgvParser() {
 		"Stream chunking algorithm chain. One of: "+text.AvailableMapKeys(availableChunkers),
 		"chname_opt1_opt2_..._optN",
 	)
+	o.FlagLong(&cfg.requestedTransform, "leaf-transform", 0,
+		"Optional transformation of leaf data before encoding, which also affects link nodes. One of: "+text.AvailableMapKeys(availableLeafTransforms),
+		"trname_opt1_opt2_..._optN",
+	)
 	o.FlagLong(&cfg.requestedCollector, "collector", 0,
 		"Node-forming algorithm chain. One of: "+text.AvailableMapKeys(availableCollectors),
 		"colname_opt1_opt2_..._optN",
 	)
+	o.FlagLong(&cfg.preset, "preset", 0,
+		"A named set of chunker/collector/encoder/hash/inline settings matching a common ecosystem, one of: "+text.AvailableMapKeys(availablePresets)+
+			". Any conflicting option will take precedence, --help-all lists the full expansions",
+		"name",
+	)
+	o.FlagLong(&cfg.inputDecompress, "input-decompress", 0,
+		"Decompress the entire input (including any multipart framing) before processing, using one of: "+decompress.AvailableFormats()+
+			". 'auto' detects the format by magic bytes, and passes through anything unrecognized. Default:",
+		"format",
+	)
 	o.FlagLong(&cfg.emittersStdErr, "emit-stderr", 0, fmt.Sprintf(
 		"One or more emitters to activate on stdERR. Available emitters are %s. Default: ",
 		text.AvailableMapKeys(cfg.emitters),
@@ -176,6 +239,52 @@ func (cfg *config) initArgvParser() {
 	)
 }
 
+// baseArgs assembles the arguments implied by --preset and --config, in
+// increasing precedence, and leaves out anything given on the command line
+func (cfg *config) baseArgs() ([]string, error) {
+
+	var fileArgs []string
+	preset := cfg.preset
+	if cfg.ConfigFile != "" {
+		var filePreset string
+		var err error
+		if fileArgs, filePreset, err = cfg.loadConfigFile(cfg.ConfigFile); err != nil {
+			return nil, err
+		}
+		if !cfg.optSet.IsSet("preset") {
+			preset = filePreset
+		}
+	}
+
+	var presetArgs []string
+	if preset != "" {
+		var err error
+		if presetArgs, err = expandPreset(preset); err != nil {
+			return nil, err
+		}
+	}
+
+	// list options accumulate when repeated: keep only the last occurrence
+	var names []string
+	byName := make(map[string]string)
+	for _, a := range append(presetArgs, fileArgs...) {
+		n := strings.SplitN(strings.TrimPrefix(a, "--"), "=", 2)[0]
+		if cfg.optSet.IsSet(n) {
+			continue
+		}
+		if _, seen := byName[n]; !seen {
+			names = append(names, n)
+		}
+		byName[n] = a
+	}
+
+	args := make([]string, len(names))
+	for i, n := range names {
+		args[i] = byName[n]
+	}
+	return args, nil
+}
+
 func (anl *Anelace) setupEmitters() (argErrs []error) {
 
 	activeStderr := make(map[string]bool, len(anl.cfg.emittersStdErr))
@@ -233,6 +342,16 @@ func (anl *Anelace) setupEmitters() (argErrs []error) {
 	// set shortcuts based on emitter config
 	anl.generateRoots = anl.cfg.emitters[emRootsJsonl] != nil || anl.cfg.emitters[emStatsJsonl] != nil
 
+	if anl.cfg.Progress {
+		for s := range activeStderr {
+			if s != emNone && s != emStatsText {
+				argErrs = append(argErrs, fmt.Errorf("--progress can not be combined with emitter '%s' on stdERR", s))
+			}
+		}
+		anl.progressOut = anl.stderrWriter
+		anl.progressInterval = defaultProgressInterval
+	}
+
 	return
 }
 
@@ -256,6 +375,9 @@ func (anl *Anelace) setupCarWriting() (argErrs []error) {
 		if s, err := f.Stat(); err != nil {
 			log.Printf("Failed to stat() the car stream output: %s", err)
 		} else {
+			if anl.cfg.Checkpoint != "" && !s.Mode().IsRegular() {
+				argErrs = append(argErrs, fmt.Errorf("--checkpoint requires the car stream to be written to a regular file"))
+			}
 			for _, opt := range stream.WriteOptimizations {
 				if err := opt.Action(f, s); err != nil && err != os.ErrInvalid {
 					log.Printf("Failed to apply write optimization hint '%s' to car stream output: %s\n", opt.Name, err)
@@ -283,8 +405,9 @@ func (anl *Anelace) setupEncoding() (nodeEnc anlencoder.NodeEncoder, argErrs []e
 		))
 	} else {
 
-		if cfg.hashFunc == "none" && !cfg.optSet.IsSet("async-hashers") {
-			cfg.AsyncHashers = 0
+		var hashTimer func(time.Duration)
+		if anl.metrics != nil {
+			hashTimer = anl.metrics.hashDuration.observeDuration
 		}
 
 		var err error
@@ -293,6 +416,8 @@ func (anl *Anelace) setupEncoding() (nodeEnc anlencoder.NodeEncoder, argErrs []e
 			cfg.HashBits/8,
 			cfg.InlineMaxSize,
 			cfg.AsyncHashers,
+			cfg.AsyncHashersBudget,
+			hashTimer,
 		)
 		if err != nil {
 			argErrs = append(argErrs, err)
@@ -305,39 +430,53 @@ func (anl *Anelace) setupEncoding() (nodeEnc anlencoder.NodeEncoder, argErrs []e
 	}
 
 	// setup the formatter
-	var b32Encoder *base32.Encoding
-	if anl.cfg.CidMultibase == "base32" {
-		b32Encoder = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
-	} else if anl.cfg.CidMultibase != "base36" {
-		argErrs = append(argErrs, fmt.Errorf("unsupported cid multibase '%s'", anl.cfg.CidMultibase))
+	mb, found := multibase.Lookup(cfg.CidMultibase)
+	if !found {
+		argErrs = append(argErrs, fmt.Errorf(
+			"unsupported cid multibase '%s', available are %s (or the corresponding multibase prefix character)",
+			cfg.CidMultibase,
+			multibase.AvailableNames(),
+		))
 		return
 	}
 
+	// determined once the encoder is up, see below
+	var cidv0Roots bool
+
 	anl.formattedCid = func(h *anlblock.Header) (cs string) {
 
 		if h == nil {
 			return "N/A"
 		}
 
-		if b32Encoder != nil {
-			cs = "b" + b32Encoder.EncodeToString(h.Cid())
-		} else {
-			cs = "k" + base36.EncodeToStringLc(h.Cid())
+		cid := h.Cid()
+
+		// what `ipfs add` would have given: a full-length sha2-256 dag-pb node
+		// renders as a bare base58btc multihash
+		if cidv0Roots &&
+			len(cid) == 36 &&
+			cid[0] == 1 && cid[1] == byte(anlblock.CodecPB) && cid[2] == 0x12 && cid[3] == 32 {
+			return multibase.EncodeBase58btc(cid[2:])
 		}
 
+		cs = mb.Encode(cid)
+
 		// construct something usable for both humans and cid-decoders
 		if h.DummyHashed() {
-			b := []byte(cs)
-			copy(b[10:], "zzzznohash")
-			for i := 20; i < len(b); i++ {
-				b[i] = 'z'
-			}
-			cs = string(b)
+			cs = mb.Placeholder(cs, 45, "zzzznohash") // the CID header, and the established base32/base36 rendering
 		}
 
 		return
 	}
 
+	newLinkBlockCallback := func(newLinkHdr *anlblock.Header) {
+		anl.enqueueBlock(
+			newLinkHdr,
+			nil, // a link-node has no data, for now at least
+			-1,
+		)
+	}
+
 	nodeEncArgs := strings.Split(cfg.requestedNodeEncoder, "_")
 	if init, exists := availableNodeEncoders[nodeEncArgs[0]]; !exists {
 		argErrs = append(argErrs, fmt.Errorf(
@@ -356,16 +495,10 @@ func (anl *Anelace) setupEncoding() (nodeEnc anlencoder.NodeEncoder, argErrs []e
 		if nodeEnc, initErrors = init(
 			nodeEncArgs,
 			&anlencoder.AnlConfig{
-				BlockMaker: blockMaker,
-				HasherName: cfg.hashFunc,
-				HasherBits: cfg.HashBits,
-				NewLinkBlockCallback: func(newLinkHdr *anlblock.Header) {
-					anl.asyncWG.Add(1)
-					go anl.postProcessBlock(
-						newLinkHdr,
-						nil, // a link-node has no data, for now at least
-					)
-				},
+				BlockMaker:           blockMaker,
+				HasherName:           cfg.hashFunc,
+				HasherBits:           cfg.HashBits,
+				NewLinkBlockCallback: newLinkBlockCallback,
 			},
 		); len(initErrors) > 0 {
 			cfg.erroredNodeEncoders = append(cfg.erroredNodeEncoders, nodeEncArgs[0])
@@ -376,12 +509,92 @@ func (anl *Anelace) setupEncoding() (nodeEnc anlencoder.NodeEncoder, argErrs []e
 					e,
 				))
 			}
+		} else {
+			if l, isLinker := nodeEnc.(anlencoder.CIDv0Linker); isLinker && mb.Name == "base58btc" {
+				cidv0Roots = l.LinksCIDv0()
+			}
+
+			if d, isDecorator := nodeEnc.(anlencoder.RootDecorator); isDecorator && d.PreservesRootMetadata() {
+				if cfg.requestedTransform != "" {
+					argErrs = append(argErrs, fmt.Errorf("preserving root metadata can not be combined with a leaf transform"))
+				}
+				anl.rootDecorator = d
+			}
+
+			// a symlink target would otherwise bypass the transform
+			if cfg.Symlinks == "preserve" {
+				if se, isSymlinkEncoder := nodeEnc.(anlencoder.SymlinkEncoder); !isSymlinkEncoder {
+					argErrs = append(argErrs, fmt.Errorf("encoder '%s' can not represent symlinks, use --symlinks=follow", nodeEncArgs[0]))
+				} else if cfg.requestedTransform != "" {
+					argErrs = append(argErrs, fmt.Errorf("--symlinks=preserve can not be combined with a leaf transform"))
+				} else {
+					anl.symlinkEncoder = se
+				}
+			}
+
+			var transformErrs []error
+			nodeEnc, transformErrs = anl.setupLeafTransform(nodeEnc, blockMaker)
+			argErrs = append(argErrs, transformErrs...)
 		}
 	}
 
 	return
 }
 
+// Wraps the supplied node encoder, when a leaf transform was requested
+func (anl *Anelace) setupLeafTransform(
+	nodeEnc anlencoder.NodeEncoder,
+	blockMaker anlblock.Maker,
+) (_ anlencoder.NodeEncoder, argErrs []error) {
+
+	if anl.cfg.requestedTransform == "" {
+		return nodeEnc, nil
+	}
+
+	transformArgs := strings.Split(anl.cfg.requestedTransform, "_")
+	init, exists := availableLeafTransforms[transformArgs[0]]
+	if !exists {
+		return nil, []error{
+			fmt.Errorf(
+				"Leaf transform '%s' not found. Available transform names are: %s",
+				transformArgs[0],
+				text.AvailableMapKeys(availableLeafTransforms),
+			),
+		}
+	}
+
+	for n := range transformArgs {
+		if n > 0 {
+			transformArgs[n] = "--" + transformArgs[n]
+		}
+	}
+
+	transformInstance, initErrors := init(
+		transformArgs,
+		&anltransform.AnlConfig{
+			NodeEncoder:            nodeEnc,
+			BlockMaker:             blockMaker,
+			NewKeyManifestCallback: anl.enqueueKeyManifest,
+		},
+	)
+
+	if len(initErrors) > 0 {
+		anl.cfg.erroredTransforms = append(anl.cfg.erroredTransforms, transformArgs[0])
+		for _, e := range initErrors {
+			argErrs = append(argErrs, fmt.Errorf(
+				"Initialization of leaf transform '%s' failed: %s",
+				transformArgs[0],
+				e,
+			))
+		}
+		return
+	}
+
+	anl.leafTransform = transformInstance
+	anl.statSummary.KeyManifests = &keyManifestStats{}
+	return transformInstance, nil
+}
+
 func (anl *Anelace) setupChunker() (argErrs []error) {
 
 	if anl.cfg.requestedChunker == "" {
@@ -474,6 +687,12 @@ func (anl *Anelace) setupCollector(nodeEnc anlencoder.NodeEncoder) (argErrs []er
 		}
 	}
 
+	// the none collector never hands out roots: nothing would ever pick up
+	// the keys a transform accumulates
+	if anl.leafTransform != nil && collectorArgs[0] == "none" {
+		return []error{fmt.Errorf("Collector 'none' can not be combined with a leaf transform")}
+	}
+
 	collectorInstance, initErrors := init(
 		collectorArgs,
 		&anlcollector.AnlConfig{NodeEncoder: nodeEnc},
@@ -504,6 +723,8 @@ type compatIpfsArgs struct {
 	TrickleCollector bool   `getopt:"--trickle"`
 	Chunker          string `getopt:"--chunker"`
 	Hasher           string `getopt:"--hash"`
+	PreserveMode     bool   `getopt:"--preserve-mode"`
+	PreserveMtime    bool   `getopt:"--preserve-mtime"`
 }
 
 func (cfg *config) presetFromIPFS() (parseErrors []error) {
@@ -543,6 +764,19 @@ func (cfg *config) presetFromIPFS() (parseErrors []error) {
 		}
 	}
 
+	// go-ipfs always uses the entire digest
+	if !cfg.optSet.IsSet("hash-bits") {
+		if bits := anlblock.DigestBits(cfg.hashFunc); bits > 0 {
+			cfg.HashBits = bits
+		}
+	}
+
+	// go-ipfs silently switches to CIDv1 when a non-default hash is requested
+	if !optSet.IsSet("cid-version") &&
+		cfg.hashFunc != "sha2-256" && cfg.hashFunc != "sha2-256-gocore" {
+		ipfsOpts.CidVersion = 1
+	}
+
 	if !cfg.optSet.IsSet("inline-max-size") {
 		if ipfsOpts.InlineActive {
 			if optSet.IsSet("inline-limit") {
@@ -590,6 +824,13 @@ func (cfg *config) presetFromIPFS() (parseErrors []error) {
 			}
 		}
 
+		if ipfsOpts.PreserveMode {
+			ufsv1EncoderOpts = append(ufsv1EncoderOpts, "preserve-mode")
+		}
+		if ipfsOpts.PreserveMtime {
+			ufsv1EncoderOpts = append(ufsv1EncoderOpts, "preserve-mtime")
+		}
+
 		cfg.requestedNodeEncoder = strings.Join(ufsv1EncoderOpts, "_")
 	}
 
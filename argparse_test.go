@@ -0,0 +1,174 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyedChunking(t *testing.T) {
+
+	data := testData(testRand(0), 4*1024*1024)
+
+	const keyEnv = "ANELACETESTCHUNKERKEY" // no underscores: those separate chunker sub-args
+	defer os.Unsetenv(keyEnv)
+
+	ingest := func(chunker, key string) (root string, argvExpanded []string) {
+		os.Setenv(keyEnv, key) //nolint:errcheck
+
+		r := testIngest(t, bytes.NewReader(data),
+			"--emit-stdout=none",
+			"--emit-stderr=none",
+			"--chunker="+chunker+"_key-env="+keyEnv+"_state-target=0_state-mask-bits=14_min-size=4096_max-size=65536",
+		)
+		return r.roots()[0].CidString, r.anl.statSummary.SysStats.ArgvExpanded
+	}
+
+	for _, chunker := range []string{"buzhash", "rabin_window-size=16"} {
+		const keyA, keyB = "first-secret-chunking-key", "second-secret-chunking-key"
+
+		rootA1, argv := ingest(chunker, keyA)
+		rootA2, _ := ingest(chunker, keyA)
+		rootB, _ := ingest(chunker, keyB)
+
+		if rootA1 != rootA2 {
+			t.Errorf("%s: same key produced different roots:\n%s\n%s", chunker, rootA1, rootA2)
+		}
+		if rootA1 == rootB {
+			t.Errorf("%s: different keys produced identical roots: %s", chunker, rootA1)
+		}
+		for _, a := range argv {
+			if strings.Contains(a, keyA) {
+				t.Errorf("%s: secret key leaked into ArgvExpanded: %s", chunker, a)
+			}
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+
+	expanded := func(args ...string) map[string]bool {
+		anl := NewAnelaceFromArgv(append([]string{"anelace-test", "--emit-stdout=none", "--emit-stderr=none"}, args...))
+		defer anl.Destroy()
+		m := make(map[string]bool)
+		for _, a := range anl.statSummary.SysStats.ArgvExpanded {
+			m[a] = true
+		}
+		return m
+	}
+
+	for name, p := range availablePresets {
+		argv := expanded("--preset=" + name)
+		for _, a := range p.args {
+			if !argv[a] {
+				t.Errorf("preset %s: %s missing from ArgvExpanded", name, a)
+			}
+		}
+	}
+
+	if argv := expanded("--preset=kubo-default", "--chunker=fixed-size_65536"); !argv["--chunker=fixed-size_65536"] || !argv["--hash=sha2-256"] {
+		t.Error("explicit option did not take precedence over preset")
+	}
+
+	// the same settings `ipfs add` arrives at on its own
+	compat := expanded("--ipfs-add-compatible-command=--upgrade-cidv0-in-output")
+	for _, a := range availablePresets["kubo-default"].args {
+		if !compat[a] {
+			t.Errorf("kubo-default preset value %s differs from the ipfs-compatible expansion", a)
+		}
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anelace-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(fn, []byte(`{
+		"preset": "kubo-default",
+		"chunker": "fixed-size_65536",
+		"collector": { "name": "trickle", "max-direct-leaves": 174, "max-sibling-subgroups": 4, "unixfs-nul-leaf-compat": true },
+		"emit-stdout": [ "none" ],
+		"emit-stderr": [ "none" ]
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	anl := NewAnelaceFromArgv([]string{"anelace-test", "--config=" + fn, "--collector=fixed-outdegree_max-outdegree=42"})
+	defer anl.Destroy()
+	argv := anl.statSummary.SysStats.ArgvExpanded
+
+	for _, expected := range []string{
+		"--hash=sha2-256",                              // preset
+		"--inline-max-size=0",                          // preset
+		"--chunker=fixed-size_65536",                   // config overriding preset
+		"--emit-stdout=none",                           // config
+		"--collector=fixed-outdegree_max-outdegree=42", // command line overriding config
+	} {
+		found := false
+		for _, a := range argv {
+			found = found || a == expected
+		}
+		if !found {
+			t.Errorf("%s missing from ArgvExpanded %v", expected, argv)
+		}
+	}
+
+	// the dump must load back into the identical expansion
+	var dump bytes.Buffer
+	if err := dumpConfig(&dump, argv); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn, dump.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewAnelaceFromArgv([]string{"anelace-test", "--config=" + fn})
+	defer reloaded.Destroy()
+	if strings.Join(reloaded.statSummary.SysStats.ArgvExpanded, " ") != strings.Join(argv, " ") {
+		t.Errorf("dumped config does not reproduce the original settings:\n%v\n%v", argv, reloaded.statSummary.SysStats.ArgvExpanded)
+	}
+}
+
+func TestReplay(t *testing.T) {
+
+	data := testData(testRand(0), 3*1024*1024)
+
+	orig := testIngest(t, bytes.NewReader(data), "--emit-stdout=none", "--emit-stderr=none", "--preset=kubo-test-cid-v1", "--chunker=fixed-size_65536").anl
+
+	dir, err := ioutil.TempDir("", "anelace-replay-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "summary.json")
+	smr, _ := json.Marshal(orig.statSummary)
+	if err := ioutil.WriteFile(fn, smr, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := func(in []byte) error {
+		anl := testAnelace(t, nil, "--emit-stdout=none", "--replay-from="+fn, "--replay-verify")
+		defer anl.Destroy()
+		if strings.Join(anl.statSummary.SysStats.ArgvExpanded, " ") != strings.Join(orig.statSummary.SysStats.ArgvExpanded, " ") {
+			t.Errorf("replayed configuration differs:\n%v\n%v", anl.statSummary.SysStats.ArgvExpanded, orig.statSummary.SysStats.ArgvExpanded)
+		}
+		testProcess(t, anl, bytes.NewReader(in))
+		return anl.VerifyReplay()
+	}
+
+	if err := replay(data); err != nil {
+		t.Errorf("replay of identical input failed verification: %s", err)
+	}
+	data[len(data)/2]++
+	if err := replay(data); err == nil {
+		t.Error("replay of modified input unexpectedly verified")
+	}
+}
@@ -0,0 +1,345 @@
+package anelace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/anjor/anelace/internal/constants"
+	"github.com/anjor/anelace/internal/util/encoding"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const checkpointVersion = 1
+
+// a dedup record is the hash, a kind byte and two uvarints of at least a byte
+const minDedupRecordSize = seenHashSize + 3
+
+// A checkpoint file is a single JSON line, followed by checkpoint.SeenBlocks
+// binary records of the dedup index:
+//
+//	[16]byte seen key | 1 byte kind | uvarint sizeBlock | uvarint sizePayload
+//
+// where kind is 0 for link nodes, 1 for leaves and 2 for key manifests
+type checkpoint struct {
+	Version          int              `json:"checkpointVersion"`
+	CidCompatVersion int              `json:"cidCompatVersion"`
+	ArgvExpanded     []string         `json:"argvExpanded"`
+	Frames           int64            `json:"multipartFrames"` // including skipped zero-length ones
+	InputOffset      int64            `json:"inputOffset"`     // of the (decompressed) multipart stream
+	CarOffset        int64            `json:"carOffset"`       // 0 when no car stream was being written
+	Summary          statSummary      `json:"summary"`
+	SeenRoots        []checkpointRoot `json:"seenRoots,omitempty"`
+	SeenBlocks       int64            `json:"seenBlocks"`
+
+	seenBlocks seenBlocks
+}
+type checkpointRoot struct {
+	Key []byte `json:"key"`
+	Cid []byte `json:"cid"`
+}
+
+// options that must not differ between a checkpointed and a resumed run:
+// the ones unable to influence the CIDs, except for the stats-active bitfield
+// which decides whether there is a dedup index in the first place
+func checkpointComparable(argvExpanded []string) (args []string) {
+	for _, a := range argvExpanded {
+		n := strings.SplitN(strings.TrimPrefix(a, "--"), "=", 2)[0]
+		if !replayOverridable[n] || n == "stats-active" {
+			args = append(args, a)
+		}
+	}
+	return
+}
+
+// writeCheckpoint must be called from the ingestion loop between substreams
+func (anl *Anelace) writeCheckpoint() error {
+
+	// everything formed so far must be deduplicated and on disk
+	anl.asyncWG.Wait()
+	if anl.carDataQueue != nil {
+		barrier := make(chan struct{})
+		anl.carDataQueue <- carUnit{barrier: barrier}
+		select {
+		case <-barrier:
+		case err := <-anl.carWriteError:
+			return err
+		}
+		if f, isFh := anl.carDataWriter.(*os.File); isFh {
+			if s, err := f.Stat(); err == nil && s.Mode().IsRegular() {
+				if err := f.Sync(); err != nil {
+					return fmt.Errorf("unable to sync car stream before checkpointing: %s", err)
+				}
+			}
+		}
+	}
+
+	cp := checkpoint{
+		Version:          checkpointVersion,
+		CidCompatVersion: constants.CidCompatVersion,
+		ArgvExpanded:     anl.statSummary.SysStats.ArgvExpanded,
+		Frames:           anl.multipartFrames,
+		InputOffset:      anl.multipartOffset,
+	}
+	if anl.carDataQueue != nil {
+		cp.CarOffset = anl.carOffset
+	}
+
+	anl.mu.Lock()
+	defer anl.mu.Unlock()
+
+	cp.Summary = anl.statSummary
+	cp.SeenBlocks = int64(len(anl.seenBlocks))
+	cp.SeenRoots = make([]checkpointRoot, len(anl.seenRoots))
+	for k, sr := range anl.seenRoots {
+		cp.SeenRoots[sr.order] = checkpointRoot{Key: append([]byte(nil), k[:]...), Cid: sr.cid}
+	}
+
+	// write-and-rename: a crash while checkpointing leaves the previous one intact
+	tmpName := anl.cfg.Checkpoint + ".tmp"
+	fh, err := os.Create(tmpName)
+	if err != nil {
+		return fmt.Errorf("unable to create checkpoint: %s", err)
+	}
+	if err = cp.writeTo(fh, anl.seenBlocks); err == nil {
+		err = fh.Sync()
+	}
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, anl.cfg.Checkpoint)
+	}
+	if err != nil {
+		os.Remove(tmpName) //nolint:errcheck
+		return fmt.Errorf("writing checkpoint '%s' failed: %s", anl.cfg.Checkpoint, err)
+	}
+
+	return nil
+}
+
+func (cp *checkpoint) writeTo(w io.Writer, sb seenBlocks) error {
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+
+	j, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if _, err := bw.Write(append(j, '\n')); err != nil {
+		return err
+	}
+
+	rec := make([]byte, 0, seenHashSize+1+2*binary.MaxVarintLen64)
+	for k, ubs := range sb {
+		rec = append(rec[:0], k[:]...)
+		switch {
+		case ubs.isData:
+			rec = append(rec, 1)
+		case ubs.isKeyManifest:
+			rec = append(rec, 2)
+		default:
+			rec = append(rec, 0)
+		}
+		rec = encoding.AppendVarint(rec, uint64(ubs.sizeBlock))
+		rec = encoding.AppendVarint(rec, uint64(ubs.sizePayload))
+		if _, err := bw.Write(rec); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func loadCheckpoint(fn string) (*checkpoint, error) {
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open --resume checkpoint: %s", err)
+	}
+	defer fh.Close() //nolint:errcheck
+
+	br := bufio.NewReaderSize(fh, 1<<20)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --resume checkpoint '%s': %s", fn, err)
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(line, cp); err != nil {
+		return nil, fmt.Errorf("unable to parse --resume checkpoint '%s': %s", fn, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint '%s' is of unsupported version %d", fn, cp.Version)
+	}
+
+	// the record count is only a claim: size the map by what the file can hold
+	if cp.SeenBlocks < 0 {
+		return nil, fmt.Errorf("checkpoint '%s' declares %d dedup records", fn, cp.SeenBlocks)
+	}
+	sizeHint := cp.SeenBlocks
+	if fi, err := fh.Stat(); err == nil {
+		if fits := (fi.Size() - int64(len(line))) / minDedupRecordSize; sizeHint > fits {
+			sizeHint = fits
+		}
+	}
+
+	cp.seenBlocks = make(seenBlocks, sizeHint)
+	for i := int64(0); i < cp.SeenBlocks; i++ {
+		var k [seenHashSize]byte
+		var kind byte
+		var ubs uniqueBlockStats
+		var sizeBlock, sizePayload uint64
+
+		_, err = io.ReadFull(br, k[:])
+		if err == nil {
+			kind, err = br.ReadByte()
+		}
+		if err == nil {
+			sizeBlock, err = binary.ReadUvarint(br)
+		}
+		if err == nil {
+			sizePayload, err = binary.ReadUvarint(br)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint '%s' is truncated at dedup record #%d: %s", fn, i, err)
+		}
+
+		ubs.isData = (kind == 1)
+		ubs.isKeyManifest = (kind == 2)
+		ubs.sizeBlock = int(sizeBlock)
+		ubs.sizePayload = int(sizePayload)
+		cp.seenBlocks[k] = ubs
+	}
+
+	return cp, nil
+}
+
+// setupResume validates the --resume checkpoint against the current
+// configuration and car output. Nothing is modified until ProcessReader()
+func (anl *Anelace) setupResume() (argErrs []error) {
+
+	cp, err := loadCheckpoint(anl.cfg.Resume)
+	if err != nil {
+		return []error{err}
+	}
+
+	if cp.CidCompatVersion != constants.CidCompatVersion {
+		argErrs = append(argErrs, fmt.Errorf(
+			"checkpoint '%s' was produced with CID compatibility version %d, while this anelace is at version %d",
+			anl.cfg.Resume,
+			cp.CidCompatVersion,
+			constants.CidCompatVersion,
+		))
+	}
+
+	cur := make(map[string]bool)
+	for _, a := range checkpointComparable(anl.statSummary.SysStats.ArgvExpanded) {
+		cur[a] = true
+	}
+	var differing []string
+	for _, a := range checkpointComparable(cp.ArgvExpanded) {
+		if !cur[a] {
+			differing = append(differing, a)
+		}
+		delete(cur, a)
+	}
+	if len(differing) > 0 || len(cur) > 0 {
+		argErrs = append(argErrs, fmt.Errorf(
+			"checkpoint '%s' was taken with different settings, not matching the current: %s",
+			anl.cfg.Resume,
+			strings.Join(differing, " "),
+		))
+	}
+
+	if anl.carDataWriter == nil {
+		if cp.CarOffset > 0 {
+			argErrs = append(argErrs, fmt.Errorf("checkpoint '%s' was taken while writing a car stream, which is not enabled now", anl.cfg.Resume))
+		}
+	} else if cp.CarOffset == 0 {
+		argErrs = append(argErrs, fmt.Errorf("checkpoint '%s' was taken without writing a car stream, can not resume one", anl.cfg.Resume))
+	} else if f, isFh := anl.carDataWriter.(*os.File); !isFh {
+		argErrs = append(argErrs, fmt.Errorf("resuming a car stream requires it to be written to a regular file"))
+	} else if s, err := f.Stat(); err != nil {
+		argErrs = append(argErrs, fmt.Errorf("unable to stat() the car stream output: %s", err))
+	} else if !s.Mode().IsRegular() {
+		argErrs = append(argErrs, fmt.Errorf("resuming a car stream requires it to be written to a regular file"))
+	} else if s.Size() < cp.CarOffset {
+		argErrs = append(argErrs, fmt.Errorf(
+			"car stream output is %d bytes long, shorter than the %d bytes recorded in checkpoint '%s': it must be the output of the interrupted run, opened without truncation",
+			s.Size(),
+			cp.CarOffset,
+			anl.cfg.Resume,
+		))
+	}
+
+	if len(argErrs) == 0 {
+		anl.resumeFrom = cp
+	}
+	return
+}
+
+// resumeInput skips the substreams already covered by the checkpoint, and
+// restores the state of the run, including the car stream position
+func (anl *Anelace) resumeInput(inputReader io.Reader) error {
+
+	cp := anl.resumeFrom
+
+	for anl.multipartFrames < cp.Frames {
+		substreamSize, _, err := anl.readSubstreamHeader(inputReader)
+		if err != nil {
+			return fmt.Errorf("unable to skip multipart substream #%d recorded in the checkpoint: %s", anl.multipartFrames+1, err)
+		}
+		if _, err := io.CopyN(ioutil.Discard, inputReader, substreamSize); err != nil {
+			return fmt.Errorf("unable to skip multipart substream #%d recorded in the checkpoint: %s", anl.multipartFrames+1, err)
+		}
+		anl.multipartFrames++
+		anl.multipartOffset += substreamSize
+	}
+	if anl.multipartOffset != cp.InputOffset {
+		return fmt.Errorf(
+			"the first %d substreams of the input span %d bytes, while the checkpoint recorded %d: not the same input",
+			cp.Frames,
+			anl.multipartOffset,
+			cp.InputOffset,
+		)
+	}
+
+	if f, isFh := anl.carDataWriter.(*os.File); isFh && cp.CarOffset > 0 {
+		// drop whatever was written past the checkpoint before the interruption
+		if err := f.Truncate(cp.CarOffset); err != nil {
+			return fmt.Errorf("unable to truncate car stream output: %s", err)
+		}
+		if _, err := f.Seek(cp.CarOffset, io.SeekStart); err != nil {
+			return fmt.Errorf("unable to seek car stream output: %s", err)
+		}
+		anl.carOffset = cp.CarOffset
+	}
+
+	anl.statSummary.Streams = cp.Summary.Streams
+	anl.statSummary.Dag = cp.Summary.Dag
+	if cp.Summary.KeyManifests != nil {
+		anl.statSummary.KeyManifests = cp.Summary.KeyManifests
+	}
+	anl.statSummary.Roots = cp.Summary.Roots
+
+	if anl.seenBlocks != nil {
+		anl.seenBlocks = cp.seenBlocks
+		for i, r := range cp.SeenRoots {
+			var k [seenHashSize]byte
+			copy(k[:], r.Key)
+			anl.seenRoots[k] = seenRoot{order: i, cid: r.Cid}
+		}
+		if anl.metrics != nil {
+			for _, ubs := range anl.seenBlocks {
+				anl.metrics.uniqueBlocks++
+				anl.metrics.uniqueBytes += int64(ubs.sizeBlock)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package anelace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anelace-checkpoint-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	rnd := testRand(0)
+	streams := make([][]byte, 3)
+	for i := range streams {
+		streams[i] = testData(rnd, 200*1024+rnd.Intn(65536))
+	}
+	// the last substream repeats the first: its blocks must stay deduplicated across the resume
+	streams = append(streams, nil, streams[0])
+
+	ckFn := filepath.Join(dir, "checkpoint")
+	run := func(carFn string, input []byte, opts ...string) *Anelace {
+		carFh, err := os.OpenFile(carFn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer carFh.Close() //nolint:errcheck
+
+		anl := testAnelace(t, carFh, append([]string{"--multipart", "--chunker=fixed-size_65536", "--emit-stdout=car-v1-stream", "--emit-stderr=none"}, opts...)...)
+		defer anl.Destroy()
+		testProcess(t, anl, bytes.NewReader(input))
+		return anl
+	}
+
+	fullCar := filepath.Join(dir, "full.car")
+	full := run(fullCar, testMultipart(streams...))
+
+	// an interruption after 2 substreams, with some garbage written past the checkpoint
+	resumedCar := filepath.Join(dir, "resumed.car")
+	run(resumedCar, testMultipart(streams[:2]...), "--checkpoint="+ckFn, "--checkpoint-interval=0")
+	fh, err := os.OpenFile(resumedCar, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Write(streams[2][:4096]) //nolint:errcheck
+	fh.Close()                  //nolint:errcheck
+
+	resumed := run(resumedCar, testMultipart(streams...), "--resume="+ckFn)
+
+	a, _ := ioutil.ReadFile(fullCar)
+	b, _ := ioutil.ReadFile(resumedCar)
+	if !bytes.Equal(a, b) {
+		t.Errorf("resumed car stream of %d bytes differs from the uninterrupted %d bytes", len(b), len(a))
+	}
+	if !reflect.DeepEqual(full.statSummary.Roots, resumed.statSummary.Roots) ||
+		full.statSummary.Dag != resumed.statSummary.Dag ||
+		full.statSummary.Streams != resumed.statSummary.Streams ||
+		len(full.seenBlocks) != len(resumed.seenBlocks) {
+		t.Error("resumed summary differs from the uninterrupted one")
+	}
+
+	// resuming under different settings is refused
+	anl, errs := NewAnelaceFromArgvWithWriters([]string{"anelace-test", "--multipart", "--chunker=fixed-size_32768", "--emit-stdout=none", "--resume=" + ckFn}, ioutil.Discard, ioutil.Discard)
+	anl.Destroy()
+	if len(errs) == 0 {
+		t.Errorf("resuming with a different chunker did not fail")
+	}
+
+	// a corrupt record count must neither allocate accordingly nor be believed
+	for _, claimed := range []string{"-1", "1099511627776"} {
+		if err := ioutil.WriteFile(ckFn, []byte(`{"checkpointVersion":1,"seenBlocks":`+claimed+"}\n"+string(make([]byte, 64))), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCheckpoint(ckFn); err == nil {
+			t.Errorf("checkpoint claiming %s dedup records loaded successfully", claimed)
+		}
+	}
+}
@@ -10,15 +10,46 @@ import (
 
 func main() {
 
-	inStat, statErr := os.Stdin.Stat()
-	if statErr != nil {
-		log.Fatalf("unexpected error stat()ing stdIN: %s", statErr)
+	if isSubCmd, err := anelace.RunSubCommand(os.Args); isSubCmd {
+		if err != nil {
+			log.Fatalf("Fatal error: %s", err)
+		}
+		return
 	}
 
 	// Parse CLI and initialize everything
 	// On error it will log.Fatal() on its own
 	anl := anelace.NewAnelaceFromArgv(os.Args)
 
+	var processErr error
+	if len(anl.InputFiles()) > 0 {
+		processErr = anl.ProcessInputFiles(nil)
+	} else {
+		processErr = processStdin(anl)
+	}
+	anl.Destroy()
+	if processErr != nil {
+		log.Fatalf("Unexpected error processing input: %s", processErr)
+	}
+
+	anl.OutputSummary()
+
+	if err := anl.VerifyReplay(); err != nil {
+		log.Fatalf("Replay verification failed: %s", err)
+	}
+}
+
+func processStdin(anl *anelace.Anelace) error {
+
+	inStat, statErr := os.Stdin.Stat()
+	if statErr != nil {
+		log.Fatalf("unexpected error stat()ing stdIN: %s", statErr)
+	}
+
+	if inStat.Mode().IsRegular() {
+		anl.SetExpectedInputSize(inStat.Size())
+	}
+
 	if stream.IsTTY(os.Stdin) {
 		fmt.Fprint(
 			os.Stderr,
@@ -36,14 +67,8 @@ func main() {
 		}
 	}
 
-	processErr := anl.ProcessReader(
+	return anl.ProcessReader(
 		os.Stdin,
 		nil,
 	)
-	anl.Destroy()
-	if processErr != nil {
-		log.Fatalf("Unexpected error processing STDIN: %s", processErr)
-	}
-
-	anl.OutputSummary()
 }
@@ -20,10 +20,20 @@ type config struct {
 	// Bulk of CLI options definition starts here, the rest further down in initArgvParser()
 	//
 
-	Help            bool `getopt:"-h --help         Display basic help"`
-	HelpAll         bool `getopt:"--help-all        Display full help including options for every currently supported chunker/collector/encoder"`
-	MultipartStream bool `getopt:"--multipart       Expect multiple SInt64BE-size-prefixed streams on stdIN"`
-	SkipNulInputs   bool `getopt:"--skip-nul-inputs Instead of emitting an IPFS-compatible zero-length CID, skip zero-length streams outright"`
+	Help            bool   `getopt:"-h --help         Display basic help"`
+	HelpAll         bool   `getopt:"--help-all        Display full help including options for every currently supported chunker/collector/encoder"`
+	MultipartStream bool   `getopt:"--multipart       Expect multiple SInt64BE-size-prefixed streams on stdIN"`
+	MultipartTLV    bool   `getopt:"--multipart-tlv   Like --multipart, but each substream is preceded by type-length-value fields: a 1-byte type, an SInt64BE length and the value. Type 0x01 is a name and 0x02 opaque metadata, both echoed with the root. Type 0x03 is a UInt32BE of unix permission bits and 0x04 an SInt64BE of mtime seconds (optionally followed by UInt32BE nanoseconds), for encoders preserving them. The final field of type 0x00 is the substream itself"`
+	FilesFrom       string `getopt:"--files-from=filename Ingest the files listed in filename (NUL or newline separated, '-' reads the list from stdIN) after any given as parameters, each as a separate substream"`
+	SkipNulInputs   bool   `getopt:"--skip-nul-inputs Instead of emitting an IPFS-compatible zero-length CID, skip zero-length streams outright"`
+
+	inputDecompress string   // option/helptext in initArgvParser()
+	Symlinks        string   `getopt:"--symlinks=mode   How to ingest input files which are symbolic links: 'follow' ingests whatever they point to, 'preserve' encodes each as a UnixFS symlink node of its target. Default:"`
+	inputPaths      []string // the free-form parameters
+	InputMmap       bool     `getopt:"--input-mmap      When stdIN is a regular file, chunk directly over a read-only memory map of it instead of going through the ring buffer, making the --ring-buffer-* settings moot. Any other input uses the ring buffer as usual"`
+
+	GenerateRabinPoly    bool `getopt:"--generate-rabin-polynomial Print a random irreducible polynomial usable as the rabin chunker 'polynomial' and exit"`
+	GenerateBuzhashTable bool `getopt:"--generate-buzhash-table    Print a random table usable as the buzhash chunker 'hash-table-file' and exit"`
 
 	emittersStdErr []string // Emitter spec: option/helptext in initArgvParser()
 	emittersStdOut []string // Emitter spec: option/helptext in initArgvParser()
@@ -32,12 +42,17 @@ type config struct {
 	erroredChunkers     []string
 	erroredCollectors   []string
 	erroredNodeEncoders []string
+	erroredTransforms   []string
+
+	// roots listed in the --replay-from summary, for --replay-verify
+	replayRoots []string
 
 	// Recommendation in help based on largest identity CID that fits in 63 chars (dns limit)
 	// of multibase-id prefixed encoding: 1 + ceil( (4+36) * log(256) / log(36) )
 	// The base36 => 36bytes match is a coincidence: for base 32 the max value is 34 bytes
 	InlineMaxSize      int `getopt:"--inline-max-size=bytes         Use identity-CID to refer to blocks having on-wire size at or below the specified value (36 is recommended), 0 disables"`
-	AsyncHashers       int `getopt:"--async-hashers=integer         Number of concurrent short-lived goroutines performing hashing. Set to 0 (disable) for predictable benchmarking. Default:"`
+	AsyncHashers       int `getopt:"--async-hashers=integer         Number of concurrent goroutines performing hashing. Set to 0 (disable) for predictable benchmarking. Default:"`
+	AsyncHashersBudget int `getopt:"--async-hashers-budget=bytes    Maximum amount of block content queued for or undergoing asynchronous hashing at any time. Default:"`
 	RingBufferSize     int `getopt:"--ring-buffer-size=bytes        The size of the quantized ring buffer used for ingestion. Default:"`
 	RingBufferSectSize int `getopt:"--ring-buffer-sync-size=bytes   (EXPERT SETTING) The size of each buffer synchronization sector. Default:"` // option vaguely named 'sync' to not confuse users
 	RingBufferMinRead  int `getopt:"--ring-buffer-min-sysread=bytes (EXPERT SETTING) Perform next read(2) only when the specified amount of free space is available in the buffer. Default:"`
@@ -45,24 +60,43 @@ type config struct {
 	StatsActive uint `getopt:"--stats-active=uint   A bitfield representing activated stat aggregations: bit0:BlockSizing, bit1:RingbufferTiming. Default:"`
 
 	HashBits     int    `getopt:"--hash-bits=integer    Amount of bits taken from *start* of the hash output. Default:"`
-	CidMultibase string `getopt:"--cid-multibase=string Use this multibase when encoding CIDs for output. One of 'base32', 'base36'. Default:"`
+	CidMultibase string `getopt:"--cid-multibase=string Use this multibase when encoding CIDs for output. One of 'base2', 'base16', 'base32', 'base36', 'base58btc', 'base64url', or the corresponding multibase prefix character. With base58btc and CIDv0 links, eligible roots are rendered as a bare 'Qm...' CIDv0. Default:"`
 	hashFunc     string // hash function to use: option/helptext in initArgvParser()
 
 	requestedChunker     string // Chunker: option/helptext in initArgvParser()
 	requestedCollector   string // Collector: option/helptext in initArgvParser()
 	requestedNodeEncoder string // The global (for now) node=>block encoder: option/helptext in initArgvParser
-
-	IpfsCompatCmd string `getopt:"--ipfs-add-compatible-command=cmdstring A complete go-ipfs/js-ipfs add command serving as a basis config (any conflicting option will take precedence)"`
+	requestedTransform   string // Optional leaf transform wrapping the node encoder: option/helptext in initArgvParser
+
+	preset             string // option/helptext in initArgvParser()
+	ConfigFile         string `getopt:"--config=filename   A JSON file of option settings, keyed by long option name. Values override --preset, and are overridden by any option given on the command line"`
+	DumpConfig         bool   `getopt:"--dump-config       Print the fully expanded settings in --config format to stdOUT and exit"`
+	ReplayFrom         string `getopt:"--replay-from=filename A stats-jsonl summary of a previous run: reproduce its exact configuration. Only emitter, ring-buffer, async-hashing and stats options may be changed on the command line"`
+	ReplayVerify       bool   `getopt:"--replay-verify     Together with --replay-from: exit with an error unless the current input yields the same roots as the summary"`
+	MetricsListen      string `getopt:"--metrics-listen=addr Serve Prometheus metrics of the ongoing ingestion on http://addr/metrics"`
+	Progress           bool   `getopt:"--progress          Periodically report bytes read, throughput, substream, blocks and (for regular files) an ETA on stdERR"`
+	Checkpoint         string `getopt:"--checkpoint=filename With --multipart or input files: periodically record the completed substreams, their roots, the car stream offset and the dedup index, for a later --resume"`
+	CheckpointInterval int    `getopt:"--checkpoint-interval=seconds Minimum time between two --checkpoint writes, taken only once a substream completes. Default:"`
+	Resume             string `getopt:"--resume=filename  Continue an interrupted --multipart or input files ingestion from its checkpoint: the already completed substreams are skipped, and the car stream output (which must be the same file, opened without truncation) is appended to"`
+	IpfsCompatCmd      string `getopt:"--ipfs-add-compatible-command=cmdstring A complete go-ipfs/js-ipfs add command serving as a basis config (any conflicting option will take precedence)"`
 }
 
 func defaultConfig() config {
 	return config{
 		CidMultibase: "base36",
 		HashBits:     256,
-		AsyncHashers: 0, // disabling async hashers for now
+		AsyncHashers: 0, // opt-in: hashing inline keeps the goroutine count of every run predictable
+
+		// a handful of max-size blocks, but way below the ring buffer size
+		AsyncHashersBudget: 8 * constants.MaxLeafPayloadSize,
 
 		StatsActive: statsBlocks,
 
+		CheckpointInterval: 300,
+
+		inputDecompress: "none",
+		Symlinks:        "follow",
+
 		// RingBufferSize: 2*constants.HardMaxPayloadSize + 256*1024, // bare-minimum with defaults
 		RingBufferSize: 24 * 1024 * 1024, // SANCHECK low seems good somehow... fits in L3 maybe?
 
@@ -100,6 +134,8 @@ func setStatSummary() statSummary {
 			GoMaxProcs: runtime.GOMAXPROCS(-1),
 			GoVersion:  runtime.Version(),
 			CPU:        getCpu(),
+
+			CidCompatVersion: constants.CidCompatVersion,
 		},
 	}
 }
@@ -0,0 +1,148 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// options that only make sense on an actual command line
+var configFileForbidden = map[string]bool{
+	"help":                      true,
+	"help-all":                  true,
+	"config":                    true,
+	"dump-config":               true,
+	"replay-from":               true,
+	"replay-verify":             true,
+	"resume":                    true,
+	"files-from":                true,
+	"generate-rabin-polynomial": true,
+	"generate-buzhash-table":    true,
+}
+
+// loadConfigFile converts a JSON settings file into the equivalent
+// `--name=value` arguments. Keys are long option names. Values are either
+// strings/numbers/booleans, lists (for emitters), or for the plugin-selecting
+// options an object with a "name" and any number of sub-options, in place of
+// the underscore-separated form (plugins taking positional parameters, like
+// the fixed-size chunker, still need the string form):
+//
+//	{ "collector": { "name": "trickle", "max-direct-leaves": 2048, "max-sibling-subgroups": 8 } }
+func (cfg *config) loadConfigFile(fn string) (args []string, preset string, err error) {
+
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read --config file: %s", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	var settings map[string]interface{}
+	if err := dec.Decode(&settings); err != nil {
+		return nil, "", fmt.Errorf("unable to parse --config file '%s': %s", fn, err)
+	}
+
+	// Lookup() returns a typed nil for unknown names, which is not comparable to nil
+	known := make(map[string]bool)
+	cfg.optSet.VisitAll(func(o getopt.Option) { known[o.LongName()] = true })
+
+	names := make([]string, 0, len(settings))
+	for n := range settings {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if configFileForbidden[n] {
+			return nil, "", fmt.Errorf("option '%s' is not valid in --config file '%s'", n, fn)
+		}
+		if !known[n] {
+			return nil, "", fmt.Errorf("unknown option '%s' in --config file '%s'", n, fn)
+		}
+
+		val, err := configFileValue(settings[n])
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid value for '%s' in --config file '%s': %s", n, fn, err)
+		}
+
+		if n == "preset" {
+			preset = val
+		} else {
+			args = append(args, "--"+n+"="+val)
+		}
+	}
+
+	return args, preset, nil
+}
+
+func configFileValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case json.Number, bool:
+		return fmt.Sprintf("%v", val), nil
+	case []interface{}:
+		vals := make([]string, len(val))
+		for i := range val {
+			s, isString := val[i].(string)
+			if !isString {
+				return "", fmt.Errorf("list members must be strings")
+			}
+			vals[i] = s
+		}
+		return strings.Join(vals, ","), nil
+	case map[string]interface{}:
+		name, isString := val["name"].(string)
+		if !isString || name == "" {
+			return "", fmt.Errorf("an object value must have a string 'name'")
+		}
+		subNames := make([]string, 0, len(val))
+		for n := range val {
+			if n != "name" {
+				subNames = append(subNames, n)
+			}
+		}
+		sort.Strings(subNames)
+
+		parts := []string{name}
+		for _, n := range subNames {
+			switch sub := val[n].(type) {
+			case bool:
+				// flags are either present or not
+				if sub {
+					parts = append(parts, n)
+				}
+			case string, json.Number:
+				parts = append(parts, fmt.Sprintf("%s=%v", n, sub))
+			default:
+				return "", fmt.Errorf("sub-option '%s' must be a string, number or boolean", n)
+			}
+		}
+		return strings.Join(parts, "_"), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// dumpConfig renders the fully expanded settings in the format accepted by --config
+func dumpConfig(out io.Writer, argvExpanded []string) error {
+	settings := make(map[string]string, len(argvExpanded))
+	for _, a := range argvExpanded {
+		kv := strings.SplitN(strings.TrimPrefix(a, "--"), "=", 2)
+		if len(kv) == 2 {
+			settings[kv[0]] = kv[1]
+		}
+	}
+	j, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", j)
+	return err
+}
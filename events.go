@@ -0,0 +1,156 @@
+package anelace
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/anjor/anelace/internal/constants"
+)
+
+const (
+	ErrorString = IngestionEventType(iota)
+	NewRootJsonl
+	Progress
+	NewBlock
+	SubstreamStart
+	SubstreamEnd
+	Warning
+)
+
+// IngestionEvent is what ProcessReader() sends on its optional event channel.
+// Body is the rendered form: the roots-jsonl line for roots, a JSON object for
+// progress/substream/block events, the message for errors and warnings. Data
+// holds the same information structured, depending on Type:
+//
+//	ErrorString     error
+//	NewRootJsonl    *RootEvent
+//	Progress        *ProgressEvent
+//	NewBlock        *BlockEvent     only after SetBlockEvents(true)
+//	SubstreamStart  *SubstreamEvent
+//	SubstreamEnd    *SubstreamEvent
+//	Warning         *WarningEvent
+type IngestionEvent struct {
+	_    constants.Incomparabe
+	Type IngestionEventType
+	Body string
+	Data interface{}
+}
+type IngestionEventType int
+
+// RootEvent marshals to the same fields as its roots-jsonl line, plus
+// duplicate
+type RootEvent struct {
+	Stream      int64    `json:"stream"`
+	Path        string   `json:"path,omitempty"` // the input file of the substream, when ingesting named files
+	Name        string   `json:"name,omitempty"` // the --multipart-tlv name of the substream
+	Meta        []byte   `json:"meta,omitempty"` // the --multipart-tlv metadata of the substream
+	Cid         []byte   `json:"-"`              // nil when the stream produced no blocks
+	CidString   string   `json:"cid"`            // formatted according to --cid-multibase
+	Payload     uint64   `json:"payload"`
+	WireSize    uint64   `json:"wiresize"`
+	Duplicate   bool     `json:"duplicate,omitempty"` // only determined when block stats are active
+	Key         hexBytes `json:"key,omitempty"`
+	KeyManifest string   `json:"keyManifest,omitempty"`
+}
+
+// hexBytes is JSON-encoded as hex rather than base64, as in roots-jsonl
+type hexBytes []byte
+
+func (h hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *hexBytes) UnmarshalText(text []byte) (err error) {
+	*h, err = hex.DecodeString(string(text))
+	return
+}
+
+type BlockEvent struct {
+	Stream      int64  `json:"stream"`
+	Cid         []byte `json:"-"`
+	CidString   string `json:"cid"`
+	Size        int    `json:"wireSize"`
+	Payload     uint64 `json:"payload"`
+	Offset      int64  `json:"offset"` // stream offset of a leaf's payload, -1 for link nodes
+	IsLeaf      bool   `json:"leaf"`
+	KeyManifest bool   `json:"keyManifest,omitempty"` // not part of the DAG, see --leaf-transform
+	Inlined     bool   `json:"inlined,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"` // only determined when block stats are active
+}
+
+type SubstreamEvent struct {
+	Stream   int64  `json:"stream"`
+	Name     string `json:"name,omitempty"`
+	Expected int64  `json:"expectedSize"` // the --multipart declared size, -1 when not known upfront
+	Payload  int64  `json:"payload"`      // bytes processed so far, always 0 on SubstreamStart
+}
+
+type WarningEvent struct {
+	Stream  int64
+	Message string
+}
+
+// SetBlockEvents enables a NewBlock event for every block formed, including
+// duplicates, in the order the blocks are written to a car stream
+func (anl *Anelace) SetBlockEvents(enabled bool) {
+	anl.blockEvents = enabled
+}
+
+// SANCHECK - we probably want some sort of timeout or somesuch here...
+func (anl *Anelace) maybeSendEvent(t IngestionEventType, body string, data interface{}) {
+	if anl.externalEventBus != nil {
+		anl.externalEventBus <- IngestionEvent{Type: t, Body: body, Data: data}
+	}
+}
+
+func (anl *Anelace) sendWarning(format string, args ...interface{}) {
+	if anl.externalEventBus != nil {
+		we := &WarningEvent{Stream: anl.statSummary.Streams, Message: fmt.Sprintf(format, args...)}
+		anl.maybeSendEvent(Warning, we.Message, we)
+	}
+}
+
+// the roots-jsonl rendering
+func (re *RootEvent) jsonl() string {
+
+	// what is needed to decrypt the DAG goes only to the root event, never in the stats
+	var keyFields string
+	if re.Key != nil {
+		keyFields = fmt.Sprintf(`, "key":"%x"`, re.Key)
+		if re.KeyManifest != "" {
+			keyFields += fmt.Sprintf(`, "keyManifest":"%s"`, re.KeyManifest)
+		}
+	}
+
+	// JSON-escaped, the metadata base64-encoded
+	var pathField string
+	if re.Path != "" {
+		p, _ := json.Marshal(re.Path)
+		pathField = `, "path":` + string(p)
+	}
+	if re.Name != "" {
+		n, _ := json.Marshal(re.Name)
+		pathField += `, "name":` + string(n)
+	}
+	if re.Meta != nil {
+		m, _ := json.Marshal(re.Meta)
+		pathField += `, "meta":` + string(m)
+	}
+
+	return fmt.Sprintf(
+		"{\"event\":   \"root\", \"payload\":%12d, \"stream\":%7d, %-67s, \"wiresize\":%12d%s%s }\n",
+		re.Payload,
+		re.Stream,
+		fmt.Sprintf(`"cid":"%s"`, re.CidString),
+		re.WireSize,
+		pathField,
+		keyFields,
+	)
+}
+
+// renders a struct with at least one always-present field, prepending the
+// event type
+func jsonlEvent(eventType string, v interface{}) string {
+	j, _ := json.Marshal(v)
+	return `{"event":"` + eventType + `",` + string(j[1:]) + "\n"
+}
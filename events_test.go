@@ -0,0 +1,136 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/anjor/anelace/internal/util/car"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestTypedEvents(t *testing.T) {
+
+	rnd := testRand(0)
+	stream := testData(rnd, 300*1024+rnd.Intn(65536))
+
+	var carBuf bytes.Buffer
+	anl := testAnelace(t, &carBuf, "--emit-stdout=car-v1-stream", "--emit-stderr=none", "--multipart", "--chunker=fixed-size_65536")
+	defer anl.Destroy()
+	anl.SetBlockEvents(true)
+
+	// the same substream twice, then an empty one
+	events := testProcess(t, anl, bytes.NewReader(testMultipart(stream, stream, nil)))
+
+	var roots []*RootEvent
+	var uniqueBlocks [][]byte
+	var starts, ends, warnings int
+	leafOffsets := make(map[int64]int64)
+
+	for _, ev := range events {
+
+		var body map[string]interface{}
+		if ev.Type != ErrorString && ev.Type != Warning {
+			if err := json.Unmarshal([]byte(ev.Body), &body); err != nil {
+				t.Fatalf("event body is not JSON: %s", ev.Body)
+			}
+		}
+
+		switch d := ev.Data.(type) {
+		case *WarningEvent:
+			warnings++
+		case *SubstreamEvent:
+			if ev.Type == SubstreamStart {
+				starts++
+			} else if d.Payload != d.Expected {
+				t.Errorf("substream #%d ended after %d bytes, expected %d", d.Stream, d.Payload, d.Expected)
+			} else {
+				ends++
+			}
+		case *RootEvent:
+			if body["cid"] != d.CidString || int64(body["stream"].(float64)) != d.Stream {
+				t.Errorf("root event body %s does not match %+v", ev.Body, d)
+			}
+			// the marshaled struct carries the same fields as the body
+			var marshaled map[string]interface{}
+			j, _ := json.Marshal(d)
+			json.Unmarshal(j, &marshaled) //nolint:errcheck
+			delete(marshaled, "duplicate")
+			delete(body, "event")
+			if !reflect.DeepEqual(marshaled, body) {
+				t.Errorf("marshaled root event %s does not match its body %s", j, ev.Body)
+			}
+			roots = append(roots, d)
+		case *BlockEvent:
+			if d.IsLeaf {
+				if d.Offset != leafOffsets[d.Stream] {
+					t.Errorf("leaf at offset %d of substream #%d, expected %d", d.Offset, d.Stream, leafOffsets[d.Stream])
+				}
+				leafOffsets[d.Stream] += int64(d.Payload)
+			}
+			if !d.Duplicate && !d.Inlined {
+				uniqueBlocks = append(uniqueBlocks, d.Cid)
+			}
+		}
+	}
+	if starts != 3 || ends != 3 || len(roots) != 3 {
+		t.Fatalf("unexpected amount of substream starts %d, ends %d, roots %d", starts, ends, len(roots))
+	}
+	if !bytes.Equal(roots[0].Cid, roots[1].Cid) || roots[0].Duplicate || !roots[1].Duplicate || warnings != 1 {
+		t.Errorf("duplicate root not flagged correctly: %+v %+v, %d warnings", roots[0], roots[1], warnings)
+	}
+	for s := int64(1); s <= 2; s++ {
+		if leafOffsets[s] != int64(len(stream)) {
+			t.Errorf("leaves of substream #%d cover %d bytes, expected %d", s, leafOffsets[s], len(stream))
+		}
+	}
+
+	// the non-duplicate block events are exactly the car contents, in order
+	cr := car.NewReader(&carBuf)
+	for i := 0; ; i++ {
+		cid, _, err := cr.Next()
+		if err == io.EOF {
+			if i != len(uniqueBlocks) {
+				t.Errorf("car holds %d blocks, events announced %d unique ones", i, len(uniqueBlocks))
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(uniqueBlocks) || !bytes.Equal(cid, uniqueBlocks[i]) {
+			t.Fatalf("car block #%d %x does not match the block events", i, cid)
+		}
+	}
+}
+
+func TestRootEventJSON(t *testing.T) {
+
+	re := &RootEvent{
+		Stream:      3,
+		Path:        "dir/\"file\"",
+		Name:        "name",
+		Meta:        []byte{0, 1, 2},
+		Cid:         []byte{0x01, 0x55, 0x00, 0x00},
+		CidString:   "bafkqaaa",
+		Payload:     42,
+		WireSize:    64,
+		Key:         []byte{0xde, 0xad, 0xbe, 0xef},
+		KeyManifest: "bafkreikey",
+	}
+
+	var jsonl, marshaled map[string]interface{}
+	if err := json.Unmarshal([]byte(re.jsonl()), &jsonl); err != nil {
+		t.Fatal(err)
+	}
+	delete(jsonl, "event")
+	j, _ := json.Marshal(re)
+	json.Unmarshal(j, &marshaled) //nolint:errcheck
+	if !reflect.DeepEqual(marshaled, jsonl) {
+		t.Errorf("marshaled root event %s does not match roots-jsonl %s", j, re.jsonl())
+	}
+
+	var roundtrip RootEvent
+	if err := json.Unmarshal(j, &roundtrip); err != nil || !bytes.Equal(roundtrip.Key, re.Key) {
+		t.Errorf("key did not survive a JSON roundtrip: %x %v", roundtrip.Key, err)
+	}
+}
@@ -0,0 +1,197 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/anjor/anelace/internal/encoder"
+	"github.com/anjor/anelace/internal/util/stream"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+type inputFile struct {
+	path          string
+	size          int64
+	symlinkTarget string // only with --symlinks=preserve
+	rootMeta      anlencoder.RootMetadata
+}
+
+// readFilesFrom returns the NUL-separated list of paths in fn, or the
+// newline-separated one if there is not a single NUL in it. "-" is stdIN.
+func readFilesFrom(fn string) (paths []string, err error) {
+
+	var list []byte
+	if fn == "-" {
+		list, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		list, err = ioutil.ReadFile(fn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --files-from list: %s", err)
+	}
+
+	sep := []byte("\n")
+	if bytes.IndexByte(list, 0) >= 0 {
+		sep = []byte{0}
+	}
+	for _, p := range bytes.Split(list, sep) {
+		if len(p) > 0 {
+			paths = append(paths, string(p))
+		}
+	}
+	return
+}
+
+// the sizes are taken upfront: they make up the multipart framing
+func statInputFiles(paths []string, preserveSymlinks bool) (files []inputFile, argErrs []error) {
+	for _, p := range paths {
+
+		stat := os.Stat
+		if preserveSymlinks {
+			stat = os.Lstat
+		}
+
+		s, err := stat(p)
+		if err != nil {
+			argErrs = append(argErrs, fmt.Errorf("unable to stat() input file: %s", err))
+			continue
+		}
+
+		f := inputFile{
+			path: p,
+			size: s.Size(),
+			rootMeta: anlencoder.RootMetadata{
+				Mode:    unixPerms(s.Mode()),
+				HasMode: true,
+				Mtime:   s.ModTime(),
+			},
+		}
+
+		if s.Mode()&os.ModeSymlink != 0 {
+			if f.symlinkTarget, err = os.Readlink(p); err != nil {
+				argErrs = append(argErrs, fmt.Errorf("unable to read input symlink: %s", err))
+				continue
+			}
+			// an empty substream in the framing
+			f.size = 0
+		} else if !s.Mode().IsRegular() {
+			argErrs = append(argErrs, fmt.Errorf("input '%s' is not a regular file", p))
+			continue
+		}
+
+		files = append(files, f)
+	}
+	return
+}
+
+// unixPerms is the permission part of a os.FileMode, as stored in UnixFS
+func unixPerms(m os.FileMode) uint32 {
+	perms := uint32(m & os.ModePerm)
+	if m&os.ModeSetuid != 0 {
+		perms |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		perms |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		perms |= 01000
+	}
+	return perms
+}
+
+// InputFiles returns the files given as parameters on the command line and via
+// --files-from, in the order ProcessInputFiles() ingests them
+func (anl *Anelace) InputFiles() []string {
+	paths := make([]string, len(anl.inputFiles))
+	for i := range anl.inputFiles {
+		paths[i] = anl.inputFiles[i].path
+	}
+	return paths
+}
+
+// ProcessInputFiles ingests every InputFiles() entry as a separate substream,
+// exactly as if they were framed for --multipart. Their roots carry the path.
+func (anl *Anelace) ProcessInputFiles(optionalEventChan chan<- IngestionEvent) error {
+
+	if anl.expectedInputSize == 0 {
+		for _, f := range anl.inputFiles {
+			anl.expectedInputSize += 8 + f.size
+		}
+	}
+
+	anl.cfg.MultipartStream = true
+	return anl.ProcessReader(&filesReader{files: anl.inputFiles}, optionalEventChan)
+}
+
+// filesReader renders a list of files as a --multipart stream, opening each
+// one only once the previous is exhausted
+type filesReader struct {
+	files []inputFile
+	next  int
+	cur   *os.File
+	body  *io.LimitedReader
+	r     io.Reader
+}
+
+func (fr *filesReader) Read(p []byte) (int, error) {
+	for {
+		if fr.r != nil {
+			n, err := fr.r.Read(p)
+			if err != io.EOF {
+				return n, err
+			}
+
+			if fr.cur != nil {
+				fr.cur.Close() //nolint:errcheck
+			}
+			if fr.body.N > 0 {
+				return n, fmt.Errorf(
+					"input file '%s' ended %d bytes short of its original size of %d bytes",
+					fr.cur.Name(),
+					fr.body.N,
+					fr.files[fr.next-1].size,
+				)
+			}
+			fr.r = nil
+			if n > 0 {
+				return n, nil
+			}
+		}
+
+		if fr.next >= len(fr.files) {
+			return 0, io.EOF
+		}
+		f := fr.files[fr.next]
+		fr.next++
+
+		var hdr [8]byte
+		binary.BigEndian.PutUint64(hdr[:], uint64(f.size))
+
+		// nothing to open, the framing is all there is
+		if f.symlinkTarget != "" {
+			fr.cur = nil
+			fr.body = &io.LimitedReader{}
+			fr.r = bytes.NewReader(hdr[:])
+			continue
+		}
+
+		fh, err := os.Open(f.path)
+		if err != nil {
+			return 0, err
+		}
+		if s, err := fh.Stat(); err == nil {
+			for _, opt := range stream.ReadOptimizations {
+				if err := opt.Action(fh, s); err != nil && err != os.ErrInvalid {
+					log.Printf("Failed to apply read optimization hint '%s' to '%s': %s\n", opt.Name, f.path, err)
+				}
+			}
+		}
+
+		fr.cur = fh
+		fr.body = &io.LimitedReader{R: fh, N: f.size}
+		fr.r = io.MultiReader(bytes.NewReader(hdr[:]), fr.body)
+	}
+}
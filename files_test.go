@@ -0,0 +1,200 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputFiles(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anelace-files-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	rnd := testRand(0)
+
+	var paths []string
+	var contents [][]byte
+	for i, n := range []string{"first", "with\nnewline", "empty", "last"} {
+		data := testData(rnd, rnd.Intn(300*1024))
+		if n == "empty" {
+			data = nil
+		}
+
+		paths = append(paths, filepath.Join(dir, n))
+		if err := ioutil.WriteFile(paths[i], data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, data)
+	}
+
+	// everything but the first goes in a NUL-separated list
+	listFn := filepath.Join(dir, "list")
+	if err := ioutil.WriteFile(listFn, []byte(strings.Join(paths[1:], "\x00")+"\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ingest := func(input io.Reader, argv ...string) (roots []*RootEvent, car []byte) {
+		r := testIngest(t, input, append([]string{"--emit-stdout=car-v1-stream", "--emit-stderr=none"}, argv...)...)
+		return r.roots(), r.stdout
+	}
+
+	// options may follow the file parameters
+	fileRoots, fileCar := ingest(nil, paths[0], "--files-from="+listFn, "--chunker=fixed-size_65536")
+	mpRoots, mpCar := ingest(bytes.NewReader(testMultipart(contents...)), "--multipart", "--chunker=fixed-size_65536")
+
+	if !bytes.Equal(fileCar, mpCar) {
+		t.Fatal("car stream of input files differs from the --multipart one")
+	}
+	if len(fileRoots) != len(paths) || len(mpRoots) != len(paths) {
+		t.Fatalf("expected %d roots, got %d from files and %d from --multipart", len(paths), len(fileRoots), len(mpRoots))
+	}
+	for i := range paths {
+		if fileRoots[i].CidString != mpRoots[i].CidString || fileRoots[i].Path != paths[i] || mpRoots[i].Path != "" {
+			t.Errorf("root #%d mismatch: %+v vs %+v", i+1, fileRoots[i], mpRoots[i])
+		}
+	}
+}
+
+func TestInputFileRootMetadata(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anelace-files-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	// the latter fills the root exactly: it is formed along with the last leaf
+	for _, size := range []int{450000, 4 * 65536} {
+
+		data := bytes.Repeat([]byte("metadata "), size/9+1)[:size]
+		fn := filepath.Join(dir, "file")
+		mtime := time.Unix(1600000000, 123456789)
+		if err := ioutil.WriteFile(fn, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(fn, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fn, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		field := func(b *bytes.Buffer, typ byte, val interface{}) {
+			var v bytes.Buffer
+			binary.Write(&v, binary.BigEndian, val) //nolint:errcheck
+			b.WriteByte(typ)
+			binary.Write(b, binary.BigEndian, int64(v.Len())) //nolint:errcheck
+			b.Write(v.Bytes())
+		}
+		var tlv bytes.Buffer
+		field(&tlv, tlvMode, uint32(0640))
+		field(&tlv, tlvMtime, struct {
+			Sec  int64
+			Nsec uint32
+		}{mtime.Unix(), uint32(mtime.Nanosecond())})
+		field(&tlv, tlvPayload, data)
+
+		ingest := func(argv ...string) (root string, car []byte) {
+			var input io.Reader
+			if argv[0] != fn {
+				input = bytes.NewReader(tlv.Bytes())
+			}
+			r := testIngest(t, input, append([]string{"--emit-stdout=car-v1-stream", "--emit-stderr=none", "--chunker=fixed-size_65536", "--collector=fixed-outdegree_max-outdegree=4"}, argv...)...)
+			if roots := r.roots(); len(roots) != 1 {
+				t.Fatalf("expected a single root, got %d", len(roots))
+			}
+			return r.roots()[0].CidString, r.stdout
+		}
+
+		const preserving = "--node-encoder=unixfsv1_preserve-mode_preserve-mtime"
+		plainRoot, plainCar := ingest(fn)
+		fileRoot, fileCar := ingest(fn, preserving)
+		tlvRoot, tlvCar := ingest("--multipart-tlv", preserving)
+
+		if fileRoot == plainRoot {
+			t.Errorf("%d bytes: preserved metadata did not change the root", size)
+		}
+		if fileRoot != tlvRoot || !bytes.Equal(fileCar, tlvCar) {
+			t.Errorf("%d bytes: metadata from the input file and from --multipart-tlv yield different results: %s vs %s", size, fileRoot, tlvRoot)
+		}
+
+		// only the root differs: by the mode and mtime fields
+		if len(fileCar)-len(plainCar) != 3+13 {
+			t.Errorf("%d bytes: unexpected car size difference of %d bytes", size, len(fileCar)-len(plainCar))
+		}
+	}
+}
+
+func TestInputSymlinks(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "anelace-files-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	target := filepath.Join(dir, "target")
+	if err := ioutil.WriteFile(target, bytes.Repeat([]byte("symlinked "), 10000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link, dangling := filepath.Join(dir, "link"), filepath.Join(dir, "dangling")
+	if err := os.Symlink("target", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("nowhere", dangling); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := func(argv ...string) (roots []rootStats, argErrs []error) {
+		anl, errs := NewAnelaceFromArgvWithWriters(
+			append([]string{"anelace-test", "--emit-stdout=none", "--emit-stderr=none"}, argv...),
+			ioutil.Discard,
+			ioutil.Discard,
+		)
+		defer anl.Destroy()
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		testProcess(t, anl, nil)
+		return anl.statSummary.Roots, nil
+	}
+
+	if _, errs := roots(link, dangling); len(errs) != 1 {
+		t.Errorf("expected a single error following a dangling symlink, got %v", errs)
+	}
+
+	followed, errs := roots("--cid-multibase=base16", target, link)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(followed) != 2 || followed[0].Cid != followed[1].Cid {
+		t.Errorf("followed symlink does not yield the root of its target: %+v", followed)
+	}
+
+	preserved, errs := roots("--symlinks=preserve", "--skip-nul-inputs", "--cid-multibase=base16", link, dangling, target)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(preserved) != 3 || preserved[2].Cid != followed[0].Cid {
+		t.Fatalf("unexpected roots with preserved symlinks: %+v", preserved)
+	}
+
+	// small enough to be inlined: the identity CID carries the node itself
+	for i, tgt := range []string{"target", "nowhere"} {
+		node := "\x0a" + string(rune(4+len(tgt))) + "\x08\x04\x12" + string(rune(len(tgt))) + tgt
+		if expected := fmt.Sprintf("f017000%02x%x", len(node), node); preserved[i].Cid != expected {
+			t.Errorf("symlink root %s, expected %s", preserved[i].Cid, expected)
+		}
+	}
+}
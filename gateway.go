@@ -0,0 +1,216 @@
+package anelace
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/blockstore"
+	"github.com/anjor/anelace/internal/util/car"
+	"github.com/anjor/anelace/internal/util/multibase"
+	"github.com/anjor/anelace/internal/util/unixfs"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/pborman/options"
+)
+
+type gatewayConfig struct {
+	Help          bool     `getopt:"-h --help              Display basic help"`
+	Listen        string   `getopt:"--listen=addr          Address for the gateway to listen on. Default:"`
+	Cars          []string `getopt:"--car=filename         A .car file to serve blocks from, can be given multiple times"`
+	BlockstoreDir string   `getopt:"--blockstore-dir=path  A directory populated by 'anelace serve' to serve blocks from"`
+	ZstdLeaves    bool     `getopt:"--experimental-zstd-leaves  Decompress raw leaves stored via the ingestion option of the same name when serving file content. Misreads any other leaf starting with the same marker"`
+}
+
+type carBlockLoc struct {
+	car    int
+	offset int64
+	size   int
+}
+
+// gatewayBlocks looks up blocks in the indexed CARs first, the blockstore last
+type gatewayBlocks struct {
+	cars       []*os.File
+	carIndex   map[string]carBlockLoc
+	blockstore *blockstore.Dir
+	unixfsOpts unixfs.Options
+}
+
+func (gb *gatewayBlocks) Get(cid []byte) ([]byte, error) {
+	if loc, found := gb.carIndex[string(cid)]; found {
+		data := make([]byte, loc.size)
+		if _, err := gb.cars[loc.car].ReadAt(data, loc.offset); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	if gb.blockstore != nil {
+		return gb.blockstore.Get(cid)
+	}
+	return nil, os.ErrNotExist
+}
+
+func (gb *gatewayBlocks) indexCar(fn string) error {
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	gb.cars = append(gb.cars, fh)
+
+	cr := car.NewReader(fh)
+	for {
+		cid, data, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed indexing '%s': %s", fn, err)
+		}
+		gb.carIndex[string(car.CidV1(cid))] = carBlockLoc{
+			car:    len(gb.cars) - 1,
+			offset: cr.Pos() - int64(len(data)),
+			size:   len(data),
+		}
+	}
+}
+
+// Gateway serves the DAGs contained in CARs and blockstores produced by
+// anelace, until a fatal error. argv[0] is the subcommand name.
+//
+//	GET /ipfs/<cid>[/<name>...]   the file content, honoring Range requests
+//	  ?format=car                 the entire DAG under the resolved CID as a CARv1 stream,
+//	                              same as an "Accept: application/vnd.ipld.car" header
+func Gateway(argv []string) error {
+
+	cfg := gatewayConfig{Listen: "127.0.0.1:8080"}
+
+	optSet := getopt.New()
+	if err := options.RegisterSet("", &cfg, optSet); err != nil {
+		return fmt.Errorf("option set registration failed: %s", err)
+	}
+	optSet.SetParameters("")
+
+	if errs := argparser.Parse(argv, optSet); len(errs) > 0 {
+		optSet.PrintUsage(argParseErrOut)
+		return fmt.Errorf("%s", strings.Join(getErrrStrings(errs), "\n\t"))
+	}
+	if cfg.Help {
+		optSet.PrintUsage(argParseErrOut)
+		return nil
+	}
+
+	if len(cfg.Cars) == 0 && cfg.BlockstoreDir == "" {
+		return fmt.Errorf("at least one of --car or --blockstore-dir must be specified")
+	}
+
+	gb := &gatewayBlocks{
+		carIndex:   make(map[string]carBlockLoc),
+		unixfsOpts: unixfs.Options{ZstdLeaves: cfg.ZstdLeaves},
+	}
+	for _, fn := range cfg.Cars {
+		if err := gb.indexCar(fn); err != nil {
+			return err
+		}
+	}
+	if cfg.BlockstoreDir != "" {
+		var err error
+		if gb.blockstore, err = blockstore.Open(cfg.BlockstoreDir); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/ipfs/", gb)
+
+	log.Printf("anelace gateway serving %d blocks from %d car(s) on http://%s", len(gb.carIndex), len(gb.cars), cfg.Listen)
+	return http.ListenAndServe(cfg.Listen, mux)
+}
+
+func (gb *gatewayBlocks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "only GET and HEAD are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/ipfs/"), "/"), "/")
+	cid, err := multibase.Decode(segments[0])
+	if err == nil {
+		_, _, _, err = car.ParseCid(cid)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid CID '%s': %s", segments[0], err), http.StatusBadRequest)
+		return
+	}
+
+	// walk any directories along the path
+	var n *unixfs.Node
+	for i := 0; ; i++ {
+		if n, err = unixfs.Load(gb, cid, gb.unixfsOpts); err != nil {
+			gatewayError(w, err)
+			return
+		}
+		if i == len(segments)-1 {
+			break
+		}
+		if n.Type != unixfs.TypeDirectory {
+			http.Error(w, fmt.Sprintf("'%s' is not a directory", strings.Join(segments[:i+1], "/")), http.StatusNotFound)
+			return
+		}
+		cid = nil
+		for _, l := range n.Links {
+			if l.Name == segments[i+1] {
+				cid = l.Cid
+				break
+			}
+		}
+		if cid == nil {
+			http.Error(w, fmt.Sprintf("'%s' not found", strings.Join(segments[:i+2], "/")), http.StatusNotFound)
+			return
+		}
+	}
+
+	// the content under a CID never changes
+	etag := strings.Join(segments, "/")
+	w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	w.Header().Set("X-Ipfs-Path", r.URL.Path)
+
+	if r.URL.Query().Get("format") == "car" || strings.Contains(r.Header.Get("Accept"), "application/vnd.ipld.car") {
+		w.Header().Set("Etag", `"`+etag+`.car"`)
+		w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := car.WriteHeader(w, cid); err != nil {
+			return
+		}
+		if err := unixfs.Walk(gb, cid, func(cid, data []byte) error {
+			return car.WriteSection(w, cid, data)
+		}); err != nil {
+			// too late for a status code: an incomplete CAR is the best signal available
+			log.Printf("car response for %s aborted: %s", r.URL.Path, err)
+		}
+		return
+	}
+
+	w.Header().Set("Etag", `"`+etag+`"`)
+	f, err := unixfs.NewFile(gb, n, gb.unixfsOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	http.ServeContent(w, r, segments[len(segments)-1], time.Time{}, f)
+}
+
+func gatewayError(w http.ResponseWriter, err error) {
+	if _, isMissing := err.(*unixfs.MissingBlockError); isMissing {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
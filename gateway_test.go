@@ -0,0 +1,61 @@
+package anelace
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGatewayRanges(t *testing.T) {
+
+	rnd := testRand(0)
+	data := testData(rnd, 2*1024*1024+rnd.Intn(4096))
+
+	dir, err := ioutil.TempDir("", "anelace-gateway-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gb := &gatewayBlocks{carIndex: make(map[string]carBlockLoc)}
+	defer func() {
+		for _, fh := range gb.cars {
+			fh.Close() //nolint:errcheck
+		}
+	}()
+
+	for i, args := range [][]string{
+		{"--preset=kubo-default"},
+		{"--node-encoder=unixfsv1_non-standard-lean-links", "--chunker=fixed-size_8192", "--collector=fixed-outdegree_max-outdegree=7"},
+	} {
+		r := testIngest(t, bytes.NewReader(data), append([]string{"--emit-stdout=car-v1-stream", "--emit-stderr=none"}, args...)...)
+		root := r.roots()[0].CidString
+
+		fn := filepath.Join(dir, root+".car")
+		if err := ioutil.WriteFile(fn, r.stdout, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := gb.indexCar(fn); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < 16; j++ {
+			start := rnd.Intn(len(data))
+			end := start + rnd.Intn(len(data)-start)
+
+			req := httptest.NewRequest(http.MethodGet, "/ipfs/"+root, nil)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+			rec := httptest.NewRecorder()
+			gb.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), data[start:end+1]) {
+				t.Fatalf("config #%d range %d-%d mismatch, status %d", i, start, end, rec.Code)
+			}
+		}
+	}
+}
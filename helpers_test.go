@@ -0,0 +1,108 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// All test inputs are pseudo-random but fixed, so that every failure can be
+// reproduced. Tests needing several unrelated sources offset the seed.
+const testSeed int64 = 0x616e656c616365
+
+func testRand(offset int64) *rand.Rand {
+	return rand.New(rand.NewSource(testSeed + offset))
+}
+
+// testData returns size bytes from rnd
+func testData(rnd *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+	rnd.Read(data) //nolint:errcheck
+	return data
+}
+
+// testMultipart frames the given substreams as a --multipart input
+func testMultipart(substreams ...[]byte) []byte {
+	var b bytes.Buffer
+	for _, s := range substreams {
+		binary.Write(&b, binary.BigEndian, int64(len(s))) //nolint:errcheck
+		b.Write(s)
+	}
+	return b.Bytes()
+}
+
+// testAnelace instantiates with args following the program name and stderr
+// discarded, failing the test on any argument error. A nil stdout discards
+// that too.
+func testAnelace(t *testing.T, stdout io.Writer, args ...string) *Anelace {
+	t.Helper()
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	anl, errs := NewAnelaceFromArgvWithWriters(append([]string{"anelace-test"}, args...), ioutil.Discard, stdout)
+	if len(errs) > 0 {
+		anl.Destroy()
+		t.Fatal(errs)
+	}
+	return anl
+}
+
+// testProcess runs ProcessReader() over input, or ProcessInputFiles() when
+// input is nil, and returns every event sent. Both an error event and an
+// error return fail the test.
+func testProcess(t *testing.T, anl *Anelace, input io.Reader) (events []IngestionEvent) {
+	t.Helper()
+
+	evc := make(chan IngestionEvent, 1024)
+	errc := make(chan error, 1)
+	go func() {
+		if input == nil {
+			errc <- anl.ProcessInputFiles(evc)
+		} else {
+			errc <- anl.ProcessReader(input, evc)
+		}
+	}()
+
+	for ev := range evc {
+		if ev.Type == ErrorString {
+			t.Errorf("unexpected stream processing error: %s", ev.Body)
+		}
+		events = append(events, ev)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	} else if t.Failed() {
+		t.FailNow()
+	}
+	return events
+}
+
+// testRun is the outcome of testIngest()
+type testRun struct {
+	anl    *Anelace // already destroyed: only for inspecting the final state
+	stdout []byte
+	events []IngestionEvent
+}
+
+// testIngest is testAnelace() followed by testProcess(), with the output
+// captured: --emit-stdout=car-v1-stream for instance yields the car stream
+func testIngest(t *testing.T, input io.Reader, args ...string) *testRun {
+	t.Helper()
+	var stdout bytes.Buffer
+	anl := testAnelace(t, &stdout, args...)
+	defer anl.Destroy()
+	events := testProcess(t, anl, input)
+	return &testRun{anl: anl, stdout: stdout.Bytes(), events: events}
+}
+
+func (r *testRun) roots() (roots []*RootEvent) {
+	for _, ev := range r.events {
+		if re, isRoot := ev.Data.(*RootEvent); isRoot {
+			roots = append(roots, re)
+		}
+	}
+	return roots
+}
@@ -1,11 +1,13 @@
 package anelace
 
 import (
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"github.com/anjor/anelace/internal/block"
 	"github.com/anjor/anelace/internal/chunker"
 	"github.com/anjor/anelace/internal/constants"
+	"github.com/anjor/anelace/internal/encoder"
+	"github.com/anjor/anelace/internal/util/decompress"
 	"github.com/anjor/anelace/internal/util/encoding"
 	"github.com/anjor/anelace/internal/util/text"
 	"github.com/anjor/anelace/internal/util/zcpstring"
@@ -19,33 +21,17 @@ import (
 
 // SANCHECK: not sure if any of these make sense, nor have I measured the cost
 const (
-	carQueueSize = 2048
+	carQueueSize         = 2048
+	postProcessQueueSize = 2048
 )
 
-const (
-	ErrorString = IngestionEventType(iota)
-	NewRootJsonl
-)
-
-type IngestionEvent struct {
-	_    constants.Incomparabe
-	Type IngestionEventType
-	Body string
-}
-type IngestionEventType int
-
-// SANCHECK - we probably want some sort of timeout or somesuch here...
-func (anl *Anelace) maybeSendEvent(t IngestionEventType, s string) {
-	if anl.externalEventBus != nil {
-		anl.externalEventBus <- IngestionEvent{Type: t, Body: s}
-	}
-}
-
 var preProcessTasks, postProcessTasks func(anl *Anelace)
 
 func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<- IngestionEvent) (err error) {
 
 	var t0 time.Time
+	stopProgress := func() {}
+	unmapInput := func() error { return nil }
 
 	defer func() {
 
@@ -61,7 +47,7 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 		// keep sending out events but keep at most 1 error to return synchronously
 		addErr := func(e error) {
 			if e != nil {
-				anl.maybeSendEvent(ErrorString, e.Error())
+				anl.maybeSendEvent(ErrorString, e.Error(), e)
 				select {
 				case deferErrors <- e:
 				default:
@@ -71,6 +57,10 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 
 		// we need to wait all crunching to complete, then shutdown emitter, then measure/return
 		anl.asyncWG.Wait()
+		if anl.postProcessQueue != nil {
+			close(anl.postProcessQueue)
+			anl.postProcessQueue = nil
+		}
 
 		// we are writing data: need to wait/close things
 		if anl.carDataQueue != nil {
@@ -87,6 +77,9 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 		}
 
 		anl.qrb = nil
+		anl.mappedInput, anl.mappedReader = nil, nil
+		addErr(unmapInput())
+		stopProgress()
 		if anl.externalEventBus != nil {
 			close(anl.externalEventBus)
 		}
@@ -98,12 +91,7 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 	defer func() {
 		if err != nil {
 
-			var buffered int
-			if anl.qrb != nil {
-				anl.qrb.Lock()
-				buffered = anl.qrb.Buffered()
-				anl.qrb.Unlock()
-			}
+			buffered := anl.bufferedInput()
 
 			err = fmt.Errorf(
 				"failure at byte offset %s of sub-stream #%d with %s bytes buffered/unprocessed: %s",
@@ -113,7 +101,7 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 				err,
 			)
 
-			anl.maybeSendEvent(ErrorString, err.Error())
+			anl.maybeSendEvent(ErrorString, err.Error(), err)
 		}
 	}()
 
@@ -122,26 +110,56 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 	}
 	t0 = time.Now()
 
-	anl.qrb, err = qringbuf.NewFromReader(inputReader, qringbuf.Config{
-		// MinRegion must be twice the maxchunk, otherwise chunking chains won't work (hi, Claude Shannon)
-		MinRegion:   2 * constants.MaxLeafPayloadSize,
-		MinRead:     anl.cfg.RingBufferMinRead,
-		MaxCopy:     2 * constants.MaxLeafPayloadSize, // SANCHECK having it equal to the MinRegion may be daft...
-		BufferSize:  anl.cfg.RingBufferSize,
-		SectorSize:  anl.cfg.RingBufferSectSize,
-		Stats:       &anl.statSummary.SysStats.Stats,
-		TrackTiming: ((anl.cfg.StatsActive & statsRingbuf) == statsRingbuf),
-	})
+	atomic.StoreInt64(&anl.inputBytesRead, 0)
+
+	// only the multipart framing is read() from a mapped input
+	if anl.cfg.InputMmap {
+		if view, unmap := mapInput(inputReader); view != nil {
+			anl.mappedInput, unmapInput = view, unmap
+			anl.mappedReader = bytes.NewReader(view)
+			inputReader = anl.mappedReader
+		}
+	}
+
+	inputReader = countingReader{r: inputReader, n: &anl.inputBytesRead}
+	stopProgress = anl.startProgressReporter(t0)
+
+	// everything downstream, including the multipart framing, sees the decompressed stream
+	inputReader, closeDecompressor, err := decompress.NewReader(inputReader, anl.cfg.inputDecompress)
 	if err != nil {
 		return
 	}
+	defer closeDecompressor()
+
+	if anl.mappedInput == nil {
+		anl.qrb, err = qringbuf.NewFromReader(inputReader, qringbuf.Config{
+			// MinRegion must be twice the maxchunk, otherwise chunking chains won't work (hi, Claude Shannon)
+			MinRegion:   2 * constants.MaxLeafPayloadSize,
+			MinRead:     anl.cfg.RingBufferMinRead,
+			MaxCopy:     2 * constants.MaxLeafPayloadSize, // SANCHECK having it equal to the MinRegion may be daft...
+			BufferSize:  anl.cfg.RingBufferSize,
+			SectorSize:  anl.cfg.RingBufferSectSize,
+			Stats:       &anl.statSummary.SysStats.Stats,
+			TrackTiming: ((anl.cfg.StatsActive & statsRingbuf) == statsRingbuf),
+		})
+		if err != nil {
+			return
+		}
+	}
 
 	// We got that far - got to write out the data portion prequel
 	// .oO( The machine of a dream, such a clean machine
 	//      With the pistons a pumpin', and the hubcaps all gleam )
+	// A resumed car stream already has one
 	if anl.carDataWriter != nil {
-		if _, err = io.WriteString(anl.carDataWriter, anlblock.NulRootCarHeader); err != nil {
-			return
+		if anl.resumeFrom == nil {
+			if _, err = io.WriteString(anl.carDataWriter, anlblock.NulRootCarHeader); err != nil {
+				return
+			}
+			anl.carOffset = int64(len(anlblock.NulRootCarHeader))
+			if anl.metrics != nil {
+				atomic.AddInt64(&anl.metrics.carBytes, int64(len(anlblock.NulRootCarHeader)))
+			}
 		}
 
 		// start the async writer here, once we know nothing errorred
@@ -155,6 +173,16 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 		anl.seenRoots = make(seenRoots, 32)
 	}
 
+	if anl.resumeFrom != nil {
+		if err = anl.resumeInput(inputReader); err != nil {
+			return
+		}
+	}
+	lastCheckpoint := time.Now()
+
+	anl.postProcessQueue = make(chan postProcessTask, postProcessQueueSize)
+	go anl.backgroundPostProcessor(anl.postProcessQueue)
+
 	// use 64bits everywhere
 	var substreamSize int64
 
@@ -162,32 +190,45 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 	for {
 		if anl.cfg.MultipartStream {
 
-			err := binary.Read(
-				inputReader,
-				binary.BigEndian,
-				&substreamSize,
-			)
-			anl.statSummary.SysStats.ReadCalls++
+			var err error
+			substreamSize, anl.curSubstream, err = anl.readSubstreamHeader(inputReader)
+			atomic.AddInt64(&anl.statSummary.SysStats.ReadCalls, 1)
 
 			if err == io.EOF {
 				// no new multipart coming - bail
 				break
 			} else if err != nil {
 				return fmt.Errorf(
-					"error reading next 8-byte multipart substream size: %s",
+					"error reading next multipart substream header: %s",
 					err,
 				)
 			}
+			anl.multipartFrames++
+			anl.multipartOffset += substreamSize
 
-			if substreamSize == 0 && anl.cfg.SkipNulInputs {
+			if substreamSize == 0 && anl.cfg.SkipNulInputs && anl.curSymlinkTarget() == "" {
+				anl.sendWarning("skipped zero-length substream following substream #%d", anl.statSummary.Streams)
 				continue
 			}
 
-			anl.statSummary.Streams++
+			atomic.AddInt64(&anl.statSummary.Streams, 1)
 			anl.curStreamOffset = 0
 		}
 
-		if anl.cfg.MultipartStream && substreamSize == 0 {
+		if anl.externalEventBus != nil {
+			se := &SubstreamEvent{Stream: anl.statSummary.Streams, Name: anl.curSubstream.name, Expected: -1}
+			if anl.cfg.MultipartStream {
+				se.Expected = substreamSize
+			}
+			anl.maybeSendEvent(SubstreamStart, jsonlEvent("substreamStart", se), se)
+		}
+
+		var symlinkBlock *anlblock.Header
+		if target := anl.curSymlinkTarget(); target != "" {
+			// not a stream at all: the collector is bypassed entirely
+			symlinkBlock = anl.symlinkEncoder.NewSymlink(target)
+			anl.enqueueBlock(symlinkBlock, nil, -1)
+		} else if anl.cfg.MultipartStream && substreamSize == 0 {
 			// If we got here: cfg.ProcessNulInputs is true
 			// Special case for a one-time zero-CID emission
 			anl.streamAppend(nil)
@@ -196,7 +237,7 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 				return fmt.Errorf(
 					"unexpected end of substream #%s after %s bytes (stream expected to be %s bytes long)",
 					text.Commify64(anl.statSummary.Streams),
-					text.Commify64(anl.curStreamOffset+int64(anl.qrb.Buffered())),
+					text.Commify64(anl.curStreamOffset+int64(anl.bufferedInput())),
 					text.Commify64(substreamSize),
 				)
 			} else if err != io.EOF {
@@ -210,19 +251,33 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 
 		if anl.generateRoots || anl.seenRoots != nil || anl.externalEventBus != nil {
 
-			rootBlock := anl.collector.FlushState()
+			rootBlock := symlinkBlock
+			if rootBlock == nil {
+				rootBlock = anl.collector.FlushState()
+			}
+			if anl.rootDecorator != nil {
+				rootBlock = anl.decorateRoot(rootBlock)
+			}
 
-			var rootPayloadSize, rootDagSize uint64
+			re := &RootEvent{
+				Stream:    anl.statSummary.Streams,
+				CidString: anl.formattedCid(rootBlock),
+				Name:      anl.curSubstream.name,
+				Meta:      anl.curSubstream.meta,
+			}
+			if anl.multipartFrames > 0 && anl.multipartFrames <= int64(len(anl.inputFiles)) {
+				re.Path = anl.inputFiles[anl.multipartFrames-1].path
+			}
 			if rootBlock != nil {
-				rootPayloadSize = rootBlock.SizeCumulativePayload()
-				rootDagSize = rootBlock.SizeCumulativeDag()
+				re.Cid = rootBlock.Cid()
+				re.Payload = rootBlock.SizeCumulativePayload()
+				re.WireSize = rootBlock.SizeCumulativeDag()
 
 				if anl.seenRoots != nil {
 					anl.mu.Lock()
 
-					var rootSeen bool
 					if sk := seenKey(rootBlock); sk != nil {
-						if _, rootSeen = anl.seenRoots[*sk]; !rootSeen {
+						if _, re.Duplicate = anl.seenRoots[*sk]; !re.Duplicate {
 							anl.seenRoots[*sk] = seenRoot{
 								order: len(anl.seenRoots),
 								cid:   rootBlock.Cid(),
@@ -231,24 +286,33 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 					}
 
 					anl.statSummary.Roots = append(anl.statSummary.Roots, rootStats{
-						Cid:         anl.formattedCid(rootBlock),
-						SizePayload: rootBlock.SizeCumulativePayload(),
-						SizeDag:     rootBlock.SizeCumulativeDag(),
-						Dup:         rootSeen,
+						Cid:         re.CidString,
+						Path:        re.Path,
+						Name:        re.Name,
+						Meta:        re.Meta,
+						SizePayload: re.Payload,
+						SizeDag:     re.WireSize,
+						Dup:         re.Duplicate,
 					})
 
 					anl.mu.Unlock()
 				}
+
+				if anl.leafTransform != nil {
+					if key, keyManifest := anl.leafTransform.RootKey(rootBlock); key != nil {
+						re.Key = key
+						if keyManifest != nil {
+							re.KeyManifest = anl.formattedCid(keyManifest)
+						}
+					}
+				}
 			}
 
-			jsonl := fmt.Sprintf(
-				"{\"event\":   \"root\", \"payload\":%12d, \"stream\":%7d, %-67s, \"wiresize\":%12d }\n",
-				rootPayloadSize,
-				anl.statSummary.Streams,
-				fmt.Sprintf(`"cid":"%s"`, anl.formattedCid(rootBlock)),
-				rootDagSize,
-			)
-			anl.maybeSendEvent(NewRootJsonl, jsonl)
+			jsonl := re.jsonl()
+			anl.maybeSendEvent(NewRootJsonl, jsonl, re)
+			if re.Duplicate {
+				anl.sendWarning("root %s of substream #%d duplicates an earlier root", re.CidString, re.Stream)
+			}
 			if rootBlock != nil && anl.cfg.emitters[emRootsJsonl] != nil {
 				if _, err := io.WriteString(anl.cfg.emitters[emRootsJsonl], jsonl); err != nil {
 					return fmt.Errorf("emitting '%s' failed: %s", emRootsJsonl, err)
@@ -256,6 +320,23 @@ func (anl *Anelace) ProcessReader(inputReader io.Reader, optionalEventChan chan<
 			}
 		}
 
+		anl.releaseHeldBlock()
+
+		if anl.externalEventBus != nil {
+			se := &SubstreamEvent{Stream: anl.statSummary.Streams, Name: anl.curSubstream.name, Expected: -1, Payload: anl.curStreamOffset}
+			if anl.cfg.MultipartStream {
+				se.Expected = substreamSize
+			}
+			anl.maybeSendEvent(SubstreamEnd, jsonlEvent("substreamEnd", se), se)
+		}
+
+		if anl.cfg.Checkpoint != "" && time.Since(lastCheckpoint) >= time.Duration(anl.cfg.CheckpointInterval)*time.Second {
+			if err := anl.writeCheckpoint(); err != nil {
+				return err
+			}
+			lastCheckpoint = time.Now()
+		}
+
 		// we are in EOF-state: if we are not expecting multiparts - we are done
 		if !anl.cfg.MultipartStream {
 			break
@@ -276,6 +357,10 @@ func (anl *Anelace) backgroundCarDataWriter() {
 		if !chanOpen {
 			return
 		}
+		if carUnit.barrier != nil {
+			close(carUnit.barrier)
+			continue
+		}
 
 		cid = carUnit.hdr.Cid()
 		sizeVI = encoding.AppendVarint(
@@ -288,6 +373,12 @@ func (anl *Anelace) backgroundCarDataWriter() {
 				_, err = carUnit.hdr.Content().WriteTo(anl.carDataWriter)
 			}
 		}
+		if err == nil {
+			anl.carOffset += int64(len(sizeVI) + len(cid) + carUnit.hdr.SizeBlock())
+			if anl.metrics != nil {
+				atomic.AddInt64(&anl.metrics.carBytes, int64(len(sizeVI)+len(cid)+carUnit.hdr.SizeBlock()))
+			}
+		}
 
 		carUnit.hdr.EvictContent()
 		if carUnit.region != nil {
@@ -295,21 +386,41 @@ func (anl *Anelace) backgroundCarDataWriter() {
 		}
 
 		if err != nil {
-			anl.maybeSendEvent(ErrorString, err.Error())
+			anl.maybeSendEvent(ErrorString, err.Error(), err)
 			anl.carWriteError <- err
 			return
 		}
 	}
 }
 
+// dataRegion is what the block processing needs from a *qringbuf.Region, also
+// satisfied by the regions of an --input-mmap view
+type dataRegion interface {
+	Bytes() []byte
+	Release()
+}
+
+func (anl *Anelace) bufferedInput() (buffered int) {
+	if anl.qrb != nil {
+		anl.qrb.Lock()
+		buffered = anl.qrb.Buffered()
+		anl.qrb.Unlock()
+	}
+	return
+}
+
 type splitResult struct {
 	_              constants.Incomparabe
-	chunkBufRegion *qringbuf.Region
+	chunkBufRegion dataRegion
 	chunk          anlchunker.Chunk
 }
 
 func (anl *Anelace) processStream(streamLimit int64) error {
 
+	if anl.mappedInput != nil {
+		return anl.processMappedStream(streamLimit)
+	}
+
 	// begin reading and filling buffer
 	if err := anl.qrb.StartFill(streamLimit); err != nil {
 		return err
@@ -335,14 +446,17 @@ func (anl *Anelace) processStream(streamLimit int64) error {
 			workRegion.Bytes(),
 			(readErr == io.EOF),
 			func(result anlchunker.Chunk) error {
-				sr := splitResult{
+				region := workRegion.SubRegion(processedFromReader, result.Size)
+				region.Reserve()
+				anl.streamAppend(&splitResult{
 					chunk:          result,
-					chunkBufRegion: workRegion.SubRegion(processedFromReader, result.Size),
-				}
-				sr.chunkBufRegion.Reserve()
-				anl.streamAppend(&sr)
+					chunkBufRegion: region,
+				})
 				processedFromReader += result.Size
-				anl.statSummary.Dag.Payload += int64(result.Size)
+				atomic.AddInt64(&anl.statSummary.Dag.Payload, int64(result.Size))
+				if anl.metrics != nil {
+					anl.metrics.chunkSizes.observe(float64(result.Size))
+				}
 
 				return nil
 			},
@@ -355,34 +469,161 @@ func (anl *Anelace) processStream(streamLimit int64) error {
 func (anl *Anelace) streamAppend(res *splitResult) {
 
 	var ds anlblock.DataSource
-	var dr *qringbuf.Region
+	var dr dataRegion
 	if res != nil {
 		dr = res.chunkBufRegion
 		ds.Chunk = res.chunk
 		ds.Content = zcpstring.WrapSlice(dr.Bytes())
 	}
 
+	held := anl.heldBlock.hdr
 	hdr := anl.collector.AppendData(ds)
 
+	leafOffset := anl.curStreamOffset
 	anl.curStreamOffset += int64(ds.Size)
 
+	// A link node formed by the append above already covers this leaf, and may
+	// well turn out to be the root: it stays held, the leaf goes out before it
+	if anl.rootDecorator != nil && anl.heldBlock.hdr != held {
+		anl.asyncWG.Add(1)
+		anl.postProcessQueue <- postProcessTask{
+			hdr:          hdr,
+			region:       dr,
+			stream:       anl.statSummary.Streams,
+			streamOffset: leafOffset,
+		}
+		return
+	}
+
 	// The leaf block processing is entirely decoupled from the collector chain,
 	// in order to not leak the Region lifetime management outside the framework
 	// Collectors call that same processor on intermediate link nodes they produce
-	anl.asyncWG.Add(1)
-	go anl.postProcessBlock(
+	anl.enqueueBlock(
 		hdr,
 		dr,
+		leafOffset,
 	)
 }
 
-// This function is called as multiple "fire and forget" goroutines
+type postProcessTask struct {
+	_            constants.Incomparabe
+	hdr          *anlblock.Header
+	region       dataRegion
+	stream       int64
+	streamOffset int64
+	keyManifest  bool
+}
+
+// Blocks are post-processed strictly in the order they were formed: this keeps
+// dedup decisions and the .car block order identical regardless of whether the
+// CIDs are calculated synchronously or by a pool of async hashers
+func (anl *Anelace) enqueueBlock(hdr *anlblock.Header, region dataRegion, streamOffset int64) {
+	t := postProcessTask{
+		hdr:          hdr,
+		region:       region,
+		stream:       anl.statSummary.Streams,
+		streamOffset: streamOffset,
+	}
+
+	// The root is always the last block formed: holding back exactly one
+	// keeps it decoratable, without disturbing the order
+	if anl.rootDecorator != nil {
+		t, anl.heldBlock = anl.heldBlock, t
+		if t.hdr == nil {
+			return
+		}
+	}
+
+	anl.asyncWG.Add(1)
+	anl.postProcessQueue <- t
+}
+
+// Key manifests are only ever formed alongside link blocks, and queue up
+// with them. They are never held back: a transform precludes root decoration
+func (anl *Anelace) enqueueKeyManifest(hdr *anlblock.Header) {
+	anl.asyncWG.Add(1)
+	anl.postProcessQueue <- postProcessTask{
+		hdr:          hdr,
+		stream:       anl.statSummary.Streams,
+		streamOffset: -1,
+		keyManifest:  true,
+	}
+}
+
+func (anl *Anelace) releaseHeldBlock() {
+	if anl.heldBlock.hdr != nil {
+		anl.asyncWG.Add(1)
+		anl.postProcessQueue <- anl.heldBlock
+		anl.heldBlock = postProcessTask{}
+	}
+}
+
+// curSymlinkTarget is only ever set with --symlinks=preserve
+func (anl *Anelace) curSymlinkTarget() string {
+	if anl.multipartFrames > 0 && anl.multipartFrames <= int64(len(anl.inputFiles)) {
+		return anl.inputFiles[anl.multipartFrames-1].symlinkTarget
+	}
+	return ""
+}
+
+// decorateRoot records what is known about the source of the current
+// substream on its root, which is still held back at this point
+func (anl *Anelace) decorateRoot(root *anlblock.Header) *anlblock.Header {
+
+	if root == nil || anl.heldBlock.hdr != root {
+		return root
+	}
+
+	var meta anlencoder.RootMetadata
+	if anl.multipartFrames > 0 && anl.multipartFrames <= int64(len(anl.inputFiles)) {
+		meta = anl.inputFiles[anl.multipartFrames-1].rootMeta
+	} else {
+		meta = anl.curSubstream.rootMeta
+	}
+
+	// a wrapping link block already took the place of the held one
+	root = anl.rootDecorator.DecorateRoot(root, meta)
+	anl.heldBlock.hdr = root
+	return root
+}
+
+func (anl *Anelace) backgroundPostProcessor(queue <-chan postProcessTask) {
+	for t := range queue {
+
+		// everything needed for the event must be taken before the content is released
+		var be *BlockEvent
+		if anl.blockEvents && anl.externalEventBus != nil {
+			be = &BlockEvent{
+				Stream:      t.stream,
+				Size:        t.hdr.SizeBlock(),
+				Payload:     t.hdr.SizeCumulativePayload(),
+				Offset:      t.streamOffset,
+				IsLeaf:      t.streamOffset >= 0,
+				KeyManifest: t.keyManifest,
+				Inlined:     t.hdr.IsCidInlined(),
+			}
+		}
+
+		seen := anl.postProcessBlock(t.hdr, t.region, t.keyManifest)
+
+		if be != nil {
+			be.Duplicate = seen
+			be.Cid = t.hdr.Cid()
+			be.CidString = anl.formattedCid(t.hdr)
+			anl.maybeSendEvent(NewBlock, jsonlEvent("block", be), be)
+		}
+		anl.asyncWG.Done()
+	}
+}
+
 // It may only try to send an error event, and it should(?) probably log.Fatal on its own
+// Returns whether the block was seen before, which is only known when block
+// stats are active
 func (anl *Anelace) postProcessBlock(
 	hdr *anlblock.Header,
-	dataRegion *qringbuf.Region,
-) {
-	defer anl.asyncWG.Done()
+	region dataRegion,
+	isKeyManifest bool,
+) (seen bool) {
 
 	if constants.PerformSanityChecks {
 		if hdr == nil {
@@ -392,25 +633,37 @@ func (anl *Anelace) postProcessBlock(
 		}
 	}
 
-	atomic.AddInt64(&anl.statSummary.Dag.Size, int64(hdr.SizeBlock()))
-	atomic.AddInt64(&anl.statSummary.Dag.Nodes, 1)
+	if isKeyManifest {
+		atomic.AddInt64(&anl.statSummary.KeyManifests.Size, int64(hdr.SizeBlock()))
+		atomic.AddInt64(&anl.statSummary.KeyManifests.Count, 1)
+	} else {
+		atomic.AddInt64(&anl.statSummary.Dag.Size, int64(hdr.SizeBlock()))
+		atomic.AddInt64(&anl.statSummary.Dag.Nodes, 1)
+	}
 
 	if hdr.SizeBlock() > 0 && anl.seenBlocks != nil {
 		if k := seenKey(hdr); k != nil {
 
-			var seen bool
-
 			anl.mu.Lock()
 			if _, seen = anl.seenBlocks[*k]; !seen {
-				anl.seenBlocks[*k] = uniqueBlockStats{
-					sizeBlock: hdr.SizeBlock(),
-					isData:    (dataRegion != nil),
+				ubs := uniqueBlockStats{
+					sizeBlock:     hdr.SizeBlock(),
+					isData:        (region != nil),
+					isKeyManifest: isKeyManifest,
+				}
+				if ubs.isData {
+					ubs.sizePayload = int(hdr.SizeCumulativePayload())
+				}
+				anl.seenBlocks[*k] = ubs
+				if anl.metrics != nil {
+					anl.metrics.uniqueBlocks++
+					anl.metrics.uniqueBytes += int64(ubs.sizeBlock)
 				}
 			}
 			anl.mu.Unlock()
 
 			if !seen && anl.carDataQueue != nil {
-				anl.carDataQueue <- carUnit{hdr: hdr, region: dataRegion}
+				anl.carDataQueue <- carUnit{hdr: hdr, region: region}
 				return // early return to avoid double-free below
 			}
 
@@ -426,11 +679,13 @@ func (anl *Anelace) postProcessBlock(
 		hdr.Cid()
 
 		// If we are holding parts of the qringbuf - we can drop them now
-		if dataRegion != nil {
-			dataRegion.Release()
+		if region != nil {
+			region.Release()
 		}
 
 		// Once we processed a block in this function - dump all of its content too
 		hdr.EvictContent()
 	}
+
+	return
 }
@@ -0,0 +1,95 @@
+package anelace
+
+import (
+	"github.com/anjor/anelace/internal/chunker"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// leaves formed over an --input-mmap view point straight into the map, which
+// stays valid until ProcessReader() returns
+type mappedRegion []byte
+
+func (m mappedRegion) Bytes() []byte { return m }
+func (m mappedRegion) Release()      {}
+
+const maxInt = int64(^uint(0) >> 1)
+
+// mapInput returns a nil view whenever the input is not something that can be
+// mapped, in which case the ring buffer is used as usual
+func mapInput(inputReader io.Reader) (view []byte, unmap func() error) {
+
+	f, isFh := inputReader.(*os.File)
+	if !isFh {
+		return
+	}
+	s, err := f.Stat()
+	if err != nil || !s.Mode().IsRegular() || s.Size() == 0 || s.Size() > maxInt {
+		return
+	}
+
+	// we may have been handed a file already partially read
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+
+	data, unmap, err := mmapFile(f, int(s.Size()))
+	if err != nil {
+		return nil, nil
+	}
+	if pos > int64(len(data)) {
+		pos = int64(len(data))
+	}
+
+	return data[pos:], unmap
+}
+
+// processMappedStream is the --input-mmap equivalent of processStream(): the
+// chunker sees the entire substream at once, nothing is copied
+func (anl *Anelace) processMappedStream(streamLimit int64) error {
+
+	pos := int64(len(anl.mappedInput)) - int64(anl.mappedReader.Len())
+	end := int64(len(anl.mappedInput))
+
+	endErr := io.EOF
+	if streamLimit > 0 {
+		if pos+streamLimit <= end {
+			end = pos + streamLimit
+		} else {
+			endErr = io.ErrUnexpectedEOF
+		}
+	}
+
+	buf := anl.mappedInput[pos:end]
+	var processed int
+
+	if len(buf) > 0 {
+		if err := anl.chunker.instance.Split(
+			buf,
+			true,
+			func(result anlchunker.Chunk) error {
+				anl.streamAppend(&splitResult{
+					chunk:          result,
+					chunkBufRegion: mappedRegion(buf[processed : processed+result.Size]),
+				})
+				processed += result.Size
+				atomic.AddInt64(&anl.statSummary.Dag.Payload, int64(result.Size))
+				atomic.AddInt64(&anl.inputBytesRead, int64(result.Size))
+				if anl.metrics != nil {
+					anl.metrics.chunkSizes.observe(float64(result.Size))
+				}
+
+				return nil
+			},
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err := anl.mappedReader.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	return endErr
+}
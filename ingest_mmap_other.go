@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package anelace
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(f *os.File, size int) ([]byte, func() error, error) {
+	return nil, nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package anelace
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func mmapFile(f *os.File, size int) ([]byte, func() error, error) {
+
+	data, err := unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a hint only: the chunker walks the map front to back exactly once
+	unix.Madvise(data, unix.MADV_SEQUENTIAL) //nolint:errcheck
+
+	return data, func() error { return unix.Munmap(data) }, nil
+}
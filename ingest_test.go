@@ -0,0 +1,126 @@
+package anelace
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAsyncHashingDeterminism(t *testing.T) {
+
+	data := testData(testRand(0), 24*1024*1024)
+
+	// repeat a chunk of the input, so that there is something to dedup
+	copy(data[16*1024*1024:], data[:4*1024*1024])
+
+	ingest := func(collector, asyncHashers string) *testRun {
+		return testIngest(t, bytes.NewReader(data),
+			"--emit-stdout=car-v1-stream",
+			"--emit-stderr=none",
+			"--async-hashers="+asyncHashers,
+			"--async-hashers-budget=3000000",
+			"--chunker=buzhash_hash-table=GoIPFSv0_state-target=0_state-mask-bits=15_min-size=8192_max-size=131072",
+			"--collector="+collector,
+		)
+	}
+
+	// prolly decides node boundaries by the CIDs themselves
+	for _, collector := range []string{"fixed-outdegree_max-outdegree=16", "prolly_target-outdegree=16"} {
+
+		sync := ingest(collector, "0")
+
+		for _, workers := range []string{"1", "4", "16"} {
+			async := ingest(collector, workers)
+
+			syncRoots, asyncRoots := sync.roots(), async.roots()
+			if len(syncRoots) != 1 || len(asyncRoots) != 1 || syncRoots[0].CidString != asyncRoots[0].CidString {
+				t.Fatalf("%s root mismatch between sync and %s async hashers:\n%+v\n%+v", collector, workers, syncRoots, asyncRoots)
+			}
+			if !bytes.Equal(sync.stdout, async.stdout) {
+				t.Fatalf("%s car stream produced with %s async hashers differs from the sync one", collector, workers)
+			}
+		}
+	}
+}
+
+func TestInputMmap(t *testing.T) {
+
+	rnd := testRand(0)
+	data := testData(rnd, 3*1024*1024+rnd.Intn(65536))
+	copy(data[2*1024*1024:], data[:512*1024])
+
+	fh, err := ioutil.TempFile("", "anelace-mmap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fh.Name()) //nolint:errcheck
+	defer fh.Close()           //nolint:errcheck
+	if _, err := fh.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	ingest := func(input io.Reader, mmap bool) (car []byte) {
+		r := testIngest(t, input,
+			"--emit-stdout=car-v1-stream",
+			"--emit-stderr=none",
+			"--chunker=buzhash_hash-table=GoIPFSv0_state-target=0_state-mask-bits=15_min-size=8192_max-size=131072",
+			"--input-mmap="+strconv.FormatBool(mmap),
+		)
+		if mmap && r.anl.statSummary.SysStats.ReadCalls != 0 {
+			t.Fatalf("mapped input was read() %d times", r.anl.statSummary.SysStats.ReadCalls)
+		}
+		return r.stdout
+	}
+
+	// start somewhere past the beginning of the file, as if partially consumed already
+	start := int64(rnd.Intn(4096))
+	if _, err := fh.Seek(start, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ingest(fh, true), ingest(bytes.NewReader(data[start:]), false)) {
+		t.Fatal("car stream produced over --input-mmap differs from the ring buffer one")
+	}
+}
+
+func TestKeyManifestStats(t *testing.T) {
+
+	data := testData(testRand(0), 3*1024*1024)
+
+	ingest := func(args ...string) (anl *Anelace, manifestEvents int64) {
+		anl = testAnelace(t, nil, append([]string{"--emit-stdout=none", "--emit-stderr=none", "--chunker=fixed-size_65536", "--collector=fixed-outdegree_max-outdegree=8"}, args...)...)
+		defer anl.Destroy()
+		anl.SetBlockEvents(true)
+		for _, ev := range testProcess(t, anl, bytes.NewReader(data)) {
+			if be, isBlock := ev.Data.(*BlockEvent); isBlock && be.KeyManifest {
+				manifestEvents++
+			}
+		}
+		return anl, manifestEvents
+	}
+
+	plain, _ := ingest()
+	for _, a := range plain.statSummary.SysStats.ArgvExpanded {
+		if strings.HasPrefix(a, "--leaf-transform") {
+			t.Errorf("unrequested %s present in ArgvExpanded", a)
+		}
+	}
+	if plain.statSummary.KeyManifests != nil {
+		t.Errorf("key manifest stats present without a leaf transform")
+	}
+
+	encrypted, manifestEvents := ingest("--leaf-transform=convergent")
+	km := encrypted.statSummary.KeyManifests
+
+	// 48 leaves under 6+1 link nodes, each of the latter with its own manifest
+	if encrypted.statSummary.Dag.Nodes != plain.statSummary.Dag.Nodes || plain.statSummary.Dag.Nodes != 48+7 {
+		t.Errorf("logical DAG of %d nodes, expected %d", encrypted.statSummary.Dag.Nodes, plain.statSummary.Dag.Nodes)
+	}
+	if km == nil || km.Count != 7 || manifestEvents != 7 {
+		t.Errorf("unexpected key manifest stats %+v, %d block events", km, manifestEvents)
+	}
+}
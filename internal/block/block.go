@@ -10,13 +10,17 @@ import (
 	"hash"
 	"log"
 	"math"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	sha256gocore "crypto/sha256"
+	sha512gocore "crypto/sha512"
 
 	sha256simd "github.com/minio/sha256-simd"
 	"github.com/twmb/murmur3"
 	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -34,14 +38,48 @@ var AvailableHashers = map[string]hasher{
 		multihashID: 0x12,
 		hasherMaker: sha256gocore.New,
 	},
+	"sha2-512": {
+		multihashID: 0x13,
+		hasherMaker: sha512gocore.New,
+	},
+	"sha3-224": {
+		multihashID: 0x17,
+		hasherMaker: sha3.New224,
+	},
+	"sha3-256": {
+		multihashID: 0x16,
+		hasherMaker: sha3.New256,
+	},
+	"sha3-384": {
+		multihashID: 0x15,
+		hasherMaker: sha3.New384,
+	},
 	"sha3-512": {
 		multihashID: 0x14,
 		hasherMaker: sha3.New512,
 	},
+	"keccak-256": {
+		multihashID: 0x1b,
+		hasherMaker: sha3.NewLegacyKeccak256,
+	},
 	"blake2b-256": {
 		multihashID: 0xb220,
 		hasherMaker: func() hash.Hash { hm, _ := blake2b.New256(nil); return hm },
 	},
+	"blake2b-512": {
+		multihashID: 0xb240,
+		hasherMaker: func() hash.Hash { hm, _ := blake2b.New512(nil); return hm },
+	},
+	"blake2s-256": {
+		multihashID: 0xb260,
+		hasherMaker: func() hash.Hash { hm, _ := blake2s.New256(nil); return hm },
+	},
+	// every block becomes an identity CID, regardless of --inline-max-size
+	"identity": {
+		multihashID: 0x00,
+		hasherMaker: nil,
+		identity:    true,
+	},
 	"murmur3-128": {
 		multihashID: 0x22,
 		hasherMaker: func() hash.Hash { return murmur3.New128() },
@@ -49,10 +87,20 @@ var AvailableHashers = map[string]hasher{
 	},
 }
 
+// DigestBits returns the native output size of a hasher, or 0 for hashers
+// without a fixed-size digest
+func DigestBits(hashAlg string) int {
+	if h, found := AvailableHashers[hashAlg]; found && h.hasherMaker != nil {
+		return 8 * h.hasherMaker().Size()
+	}
+	return 0
+}
+
 type hasher struct {
 	hasherMaker func() hash.Hash
 	multihashID uint
 	noExport    bool // do not allow use in car emitters
+	identity    bool
 }
 
 const (
@@ -148,14 +196,61 @@ type hashTask struct {
 	hashBasedCidLen int
 	hdr             *Header
 }
-type AsyncHashingBus chan<- hashTask
+
+// AsyncHashingBus is the handle to a pool of hashing goroutines started by
+// MakerFromConfig(). Tasks are accepted in FIFO order, and the amount of
+// block content queued or being hashed at any time is capped by a byte
+// budget: a Maker call blocks until enough of the budget is freed up. This
+// is the backpressure that keeps in-flight ring-buffer regions bounded.
+type AsyncHashingBus struct {
+	queue   chan hashTask
+	workers sync.WaitGroup
+	budget  inflightBudget
+}
+
+// Close stops accepting new tasks and waits for all workers to finish
+// whatever is already queued. It is safe to call on a nil bus.
+func (b *AsyncHashingBus) Close() {
+	if b == nil {
+		return
+	}
+	close(b.queue)
+	b.workers.Wait()
+}
+
+type inflightBudget struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	max      int
+	inflight int
+}
+
+func (ib *inflightBudget) acquire(n int) {
+	ib.mu.Lock()
+	// a block larger than the entire budget is let through on its own,
+	// otherwise we would deadlock
+	for ib.inflight > 0 && ib.inflight+n > ib.max {
+		ib.cond.Wait()
+	}
+	ib.inflight += n
+	ib.mu.Unlock()
+}
+
+func (ib *inflightBudget) release(n int) {
+	ib.mu.Lock()
+	ib.inflight -= n
+	ib.mu.Unlock()
+	ib.cond.Broadcast()
+}
 
 func MakerFromConfig(
 	hashAlg string,
 	cidHashSize int,
 	inlineMaxSize int,
 	maxAsyncHashers int,
-) (maker Maker, asyncHashQueue chan hashTask, err error) {
+	maxAsyncInflightBytes int,
+	hashTimer func(time.Duration),
+) (maker Maker, asyncHashBus *AsyncHashingBus, err error) {
 
 	hashopts, found := AvailableHashers[hashAlg]
 	if !found {
@@ -167,6 +262,10 @@ func MakerFromConfig(
 		return
 	}
 
+	if hashopts.identity {
+		inlineMaxSize = constants.MaxBlockWireSize
+	}
+
 	var nativeHashSize int
 	if hashopts.hasherMaker == nil {
 		nativeHashSize = math.MaxInt32
@@ -191,6 +290,14 @@ func MakerFromConfig(
 		return
 	}
 
+	if maxAsyncHashers > 0 && maxAsyncInflightBytes <= 0 {
+		err = fmt.Errorf(
+			"invalid non-positive value '%d' for maxAsyncInflightBytes",
+			maxAsyncInflightBytes,
+		)
+		return
+	}
+
 	// if we need to support codec ids over 127 - this will have to be switched to a map
 	var codecs [128]codecMeta
 
@@ -207,19 +314,33 @@ func MakerFromConfig(
 			hasherSingleton = hashopts.hasherMaker()
 
 		} else {
-			asyncHashQueue = make(chan hashTask, 8*maxAsyncHashers) // SANCHECK queue up to 8 times the available workers
+			asyncHashBus = &AsyncHashingBus{
+				queue:  make(chan hashTask, 8*maxAsyncHashers), // SANCHECK queue up to 8 times the available workers
+				budget: inflightBudget{max: maxAsyncInflightBytes},
+			}
+			asyncHashBus.budget.cond = sync.NewCond(&asyncHashBus.budget.mu)
 
+			asyncHashBus.workers.Add(maxAsyncHashers)
 			for i := 0; i < maxAsyncHashers; i++ {
 				go func() {
+					defer asyncHashBus.workers.Done()
 					hasher := hashopts.hasherMaker()
 					for {
-						task, chanOpen := <-asyncHashQueue
+						task, chanOpen := <-asyncHashBus.queue
 						if !chanOpen {
 							return
 						}
+						var t0 time.Time
+						if hashTimer != nil {
+							t0 = time.Now()
+						}
 						hasher.Reset()
 						task.hdr.Content().WriteTo(hasher) //nolint:errcheck
 						task.hdr.cid = (hasher.Sum(task.hdr.cid))[0:task.hashBasedCidLen:task.hashBasedCidLen]
+						if hashTimer != nil {
+							hashTimer(time.Since(t0))
+						}
+						asyncHashBus.budget.release(task.hdr.sizeBlock)
 						close(task.hdr.cidReady)
 					}
 				}()
@@ -298,13 +419,21 @@ func MakerFromConfig(
 
 			finLen := codecs[codecID].hashedCidLength
 
-			if asyncHashQueue == nil {
+			if asyncHashBus == nil {
+				var t0 time.Time
+				if hashTimer != nil {
+					t0 = time.Now()
+				}
 				hasherSingleton.Reset()
 				blockContent.WriteTo(hasherSingleton) //nolint:errcheck
 				hdr.cid = (hasherSingleton.Sum(hdr.cid))[0:finLen:finLen]
+				if hashTimer != nil {
+					hashTimer(time.Since(t0))
+				}
 			} else {
 				hdr.cidReady = make(chan struct{})
-				asyncHashQueue <- hashTask{
+				asyncHashBus.budget.acquire(hdr.sizeBlock)
+				asyncHashBus.queue <- hashTask{
 					hashBasedCidLen: finLen,
 					hdr:             hdr,
 				}
@@ -0,0 +1,142 @@
+package anlblock
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/anjor/anelace/internal/constants"
+	"github.com/anjor/anelace/internal/util/zcpstring"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncHashingMatchesSync(t *testing.T) {
+
+	seed := time.Now().UnixNano()
+	rnd := rand.New(rand.NewSource(seed))
+
+	corpus := make([][]byte, 512)
+	for i := range corpus {
+		// mix of inlineable, small and max-size blocks
+		var size int
+		switch i % 3 {
+		case 0:
+			size = rnd.Intn(64)
+		case 1:
+			size = rnd.Intn(64 * 1024)
+		default:
+			size = constants.MaxLeafPayloadSize - rnd.Intn(1024)
+		}
+		corpus[i] = make([]byte, size)
+		rnd.Read(corpus[i]) //nolint:errcheck
+	}
+
+	syncMaker, _, err := MakerFromConfig("sha2-256", 32, 36, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([][]byte, len(corpus))
+	for i := range corpus {
+		expected[i] = syncMaker(zcpstring.WrapSlice(corpus[i]), CodecRaw, uint64(len(corpus[i])), 0).Cid()
+	}
+
+	for _, tc := range []struct {
+		workers int
+		budget  int
+	}{
+		{1, 1}, // budget smaller than any block: strictly one-at-a-time
+		{4, constants.MaxLeafPayloadSize},
+		{16, 8 * constants.MaxLeafPayloadSize},
+	} {
+		asyncMaker, bus, err := MakerFromConfig("sha2-256", 32, 36, tc.workers, tc.budget, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hdrs := make([]*Header, len(corpus))
+		for i := range corpus {
+			hdrs[i] = asyncMaker(zcpstring.WrapSlice(corpus[i]), CodecRaw, uint64(len(corpus[i])), 0)
+		}
+
+		// wait for some CIDs out of order, from multiple goroutines
+		var wg sync.WaitGroup
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if !bytes.Equal(hdrs[i].Cid(), expected[i]) {
+					t.Errorf(
+						"async CID %x of block #%d (seed %d, %d workers, %d budget) does not match sync CID %x",
+						hdrs[i].Cid(), i, seed, tc.workers, tc.budget, expected[i],
+					)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		bus.Close()
+
+		if bus.budget.inflight != 0 {
+			t.Errorf("inflight budget not returned to 0 after Close(): %d", bus.budget.inflight)
+		}
+	}
+}
+
+func TestAsyncHashingCloseWaitsForQueue(t *testing.T) {
+
+	maker, bus, err := MakerFromConfig("sha2-256", 32, 0, 2, constants.MaxLeafPayloadSize, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdrs := make([]*Header, 64)
+	for i := range hdrs {
+		hdrs[i] = maker(zcpstring.WrapSlice(make([]byte, 64*1024)), CodecRaw, 64*1024, 0)
+	}
+
+	bus.Close()
+
+	for i := range hdrs {
+		select {
+		case <-hdrs[i].cidReady:
+		default:
+			t.Fatalf("block #%d still not hashed after Close() returned", i)
+		}
+	}
+}
+
+func TestAsyncHashingBudgetValidation(t *testing.T) {
+	if _, _, err := MakerFromConfig("sha2-256", 32, 0, 2, 0, nil); err == nil {
+		t.Error("zero inflight budget with async hashers enabled unexpectedly accepted")
+	}
+}
+
+func TestHasherMultihashes(t *testing.T) {
+
+	abc := []byte("abc")
+
+	for _, tc := range []struct {
+		hasher   string
+		bits     int
+		expected string
+	}{
+		{"sha2-512", 128, "01551310ddaf35a193617abacc417349ae204131"},
+		{"sha3-256", 256, "015516203a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{"keccak-256", 256, "01551b204e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"blake2s-256", 256, "0155e0e40220508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+		{"identity", 256, "01550003616263"},
+	} {
+		maker, _, err := MakerFromConfig(tc.hasher, tc.bits/8, 0, 0, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cid := fmt.Sprintf("%x", maker(zcpstring.WrapSlice(abc), CodecRaw, 3, 0).Cid()); cid != tc.expected {
+			t.Errorf("%s CID %s does not match expected %s", tc.hasher, cid, tc.expected)
+		}
+	}
+
+	if _, _, err := MakerFromConfig("sha3-224", 32, 0, 0, 0, nil); err == nil {
+		t.Error("sha3-224 unexpectedly accepted 256 hash bits")
+	}
+}
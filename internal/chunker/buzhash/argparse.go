@@ -1,10 +1,17 @@
 package buzhash
 
 import (
+	"encoding/binary"
 	"fmt"
 	"github.com/anjor/anelace/internal/chunker"
 	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/keystream"
 	"github.com/anjor/anelace/internal/util/text"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/pborman/getopt/v2"
 	"github.com/pborman/options"
@@ -32,8 +39,12 @@ func NewChunker(
 	if args == nil {
 		initErrs = argparser.SubHelp(
 			"Chunker based on hashing by cyclic polynomial, similar to the one used\n"+
-				"in 'attic-backup'. As source of \"hashing\" uses a predefined table of\n"+
-				"values selectable via the hash-table option.",
+				"in 'attic-backup'. As source of \"hashing\" uses a table of values: either\n"+
+				"a predefined one selectable via the hash-table option, one loaded from a\n"+
+				"file, or one derived from a seed. The seed is the secret key supplied via\n"+
+				"key-env or key-file: the same key always yields the same table, and chunk\n"+
+				"boundaries can no longer be used to fingerprint content by anyone not\n"+
+				"holding it. Note: names/paths given to key-env/key-file may not contain '_'.",
 			optSet,
 		)
 		return
@@ -53,16 +64,42 @@ func NewChunker(
 	c.mask = 1<<uint(c.MaskBits) - 1
 	c.target = uint32(c.TargetValue)
 
-	var exists bool
-	if c.xv, exists = hashTables[c.xvName]; !exists {
+	var tableSources []string
+	for _, o := range []string{"hash-table", "hash-table-file", "key-env", "key-file"} {
+		if optSet.IsSet(o) {
+			tableSources = append(tableSources, "'"+o+"'")
+		}
+	}
+
+	if len(tableSources) > 1 {
 		initErrs = append(initErrs, fmt.Errorf(
-			"unknown hash-table '%s' requested, available names are: %s",
-			c.xvName,
-			text.AvailableMapKeys(hashTables),
+			"options %s are mutually exclusive",
+			strings.Join(tableSources, ", "),
 		))
+	} else if optSet.IsSet("hash-table-file") {
+		var err error
+		if c.xv, err = loadTable(c.TableFile); err != nil {
+			initErrs = append(initErrs, err)
+		}
+	} else if optSet.IsSet("key-env") || optSet.IsSet("key-file") {
+		if key, err := keystream.LoadSecret(c.KeyEnv, c.KeyFile); err != nil {
+			initErrs = append(initErrs, err)
+		} else {
+			c.xv = DeriveTable(key)
+		}
+	} else {
+		var exists bool
+		if c.xv, exists = hashTables[c.xvName]; !exists {
+			initErrs = append(initErrs, fmt.Errorf(
+				"unknown hash-table '%s' requested, available names are: %s",
+				c.xvName,
+				text.AvailableMapKeys(hashTables),
+			))
+		}
 	}
 
 	c.minSansPreheat = c.MinSize - 32
+	c.multilane = anlchunker.PreferMultilaneScan && c.minSansPreheat >= 0
 
 	return &c, anlchunker.InstanceConstants{
 		MinChunkSize: c.MinSize,
@@ -70,6 +107,69 @@ func NewChunker(
 	}, initErrs
 }
 
+// DeriveTable deterministically generates a hash table based on the supplied
+// secret
+func DeriveTable(secret []byte) [256]uint32 {
+	xv, err := RandomTable(keystream.New(secret, "anelace buzhash table"))
+	if err != nil {
+		// can not happen: the keystream is endless
+		panic(err)
+	}
+	return xv
+}
+
+func RandomTable(src io.Reader) (xv [256]uint32, err error) {
+	var buf [256 * 4]byte
+	if _, err = io.ReadFull(src, buf[:]); err != nil {
+		return
+	}
+	for i := range xv {
+		xv[i] = binary.BigEndian.Uint32(buf[i*4:])
+	}
+	return
+}
+
+// FormatTable renders a table in a form suitable for --hash-table-file
+func FormatTable(xv [256]uint32) string {
+	var b strings.Builder
+	for i := range xv {
+		fmt.Fprintf(&b, "0x%08x", xv[i])
+		if i%8 == 7 {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+func loadTable(fn string) (xv xorVector, err error) {
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return xv, fmt.Errorf("unable to read hash-table-file: %s", err)
+	}
+
+	vals := strings.FieldsFunc(string(content), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(vals) != len(xv) {
+		return xv, fmt.Errorf(
+			"hash-table-file '%s' contains %d values, expected exactly %d",
+			fn, len(vals), len(xv),
+		)
+	}
+
+	for i := range vals {
+		v, err := strconv.ParseUint(vals[i], 0, 32)
+		if err != nil {
+			return xv, fmt.Errorf("hash-table-file '%s' value #%d: %s", fn, i, err)
+		}
+		xv[i] = uint32(v)
+	}
+
+	return
+}
+
 var hashTables = map[string]xorVector{
 	"GoIPFSv0": {
 		0x6236e7d5, 0x10279b0b, 0x72818182, 0xdc526514, 0x2fd41e3d, 0x777ef8c8, 0x83ee5285, 0x2c8f3637,
@@ -10,14 +10,18 @@ type config struct {
 	MaskBits    int    `getopt:"--state-mask-bits=[5:22]  Amount of bits of state to compare to target on every iteration. For random input average chunk size is about 2**m (IPFS default: 17)"`
 	MaxSize     int    `getopt:"--max-size=[1:MaxPayload] Maximum data chunk size (IPFS default: 524288)"`
 	MinSize     int    `getopt:"--min-size=[0:MaxPayload] Minimum data chunk size (IPFS default: 131072)"`
+	TableFile   string `getopt:"--hash-table-file=path   Load the hash table from a file containing 256 whitespace/comma separated uint32 values"`
+	KeyEnv      string `getopt:"--key-env=varname        Derive the hash table from a seed: the secret key held in this environment variable. Boundaries become unpredictable without the key"`
+	KeyFile     string `getopt:"--key-file=path          Derive the hash table from a seed: the secret key read from this file. Boundaries become unpredictable without the key"`
 	xvName      string // getopt attached dynamically during init
 }
 
 type buzhashChunker struct {
-	// derived from the tables at the end of the file, selectable via --hash-table
+	// derived from the tables at the end of argparse.go, or from a file/key
 	mask           uint32
 	target         uint32
 	minSansPreheat int
+	multilane      bool
 	xv             xorVector
 	config
 }
@@ -55,6 +59,15 @@ func (c *buzhashChunker) Split(
 			return
 		}
 
+		if c.multilane {
+			curIdx = c.scanMultilane(buf, curIdx+c.MinSize, nextRoundMax)
+			err = cb(anlchunker.Chunk{Size: curIdx - lastIdx})
+			if err != nil {
+				return
+			}
+			continue
+		}
+
 		// reset
 		state = 0
 
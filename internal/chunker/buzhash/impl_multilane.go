@@ -0,0 +1,90 @@
+package buzhash
+
+import (
+	"math/bits"
+)
+
+// With a 32-bit state rotated once per byte, a byte entering the window is
+// rotated back into its original position exactly when it leaves it. The
+// state at any offset is therefore a pure function of the preceding 32
+// bytes, and can be computed for several far-apart offsets at once. We run
+// multiLanes independent rolling states over adjacent spans of the buffer,
+// interleaved in a single loop so that the CPU can overlap the otherwise
+// strictly sequential table lookups.
+const (
+	multiLanes       = 4
+	multiLaneMaxSpan = 4096
+	multiLaneMinSpan = 256
+)
+
+func (c *buzhashChunker) preheat(buf []byte, idx int) (state uint32) {
+	for i := idx - 32; i < idx; i++ {
+		state = bits.RotateLeft32(state, 1) ^ c.xv[buf[i]]
+	}
+	return
+}
+
+// Returns the first index within [from:to) at which the state of the
+// preceding 32-byte window matches the target, or `to` if there is none.
+// Result is identical to what the byte-at-a-time cycle in Split() arrives at.
+func (c *buzhashChunker) scanMultilane(buf []byte, from, to int) int {
+
+	mask, target := c.mask, c.target
+
+	for to-from >= multiLanes*multiLaneMinSpan {
+
+		span := (to - from) / multiLanes
+		if span > multiLaneMaxSpan {
+			span = multiLaneMaxSpan
+		}
+
+		p0, p1, p2, p3 := from, from+span, from+2*span, from+3*span
+		s0, s1, s2, s3 := c.preheat(buf, p0), c.preheat(buf, p1), c.preheat(buf, p2), c.preheat(buf, p3)
+
+		// pre-sliced lanes let the compiler drop most bounds checks in the loop
+		xv := &c.xv
+		b0, b1, b2, b3 := buf[p0-32:p0+span], buf[p1-32:p1+span], buf[p2-32:p2+span], buf[p3-32:p3+span]
+
+		for i := 0; i < span; i++ {
+
+			if (s0&mask) == target ||
+				(s1&mask) == target ||
+				(s2&mask) == target ||
+				(s3&mask) == target {
+
+				// Something matched: the lowest matching lane provides a candidate,
+				// but any lane before it may still match further along its span
+				lanes := [multiLanes]struct {
+					pos   int
+					state uint32
+				}{{p0 + i, s0}, {p1 + i, s1}, {p2 + i, s2}, {p3 + i, s3}}
+
+				for l := range lanes {
+					if (lanes[l].state & mask) == target {
+						return lanes[l].pos
+					}
+					if found := c.scanScalar(buf, lanes[l].state, lanes[l].pos, from+(l+1)*span); found < from+(l+1)*span {
+						return found
+					}
+				}
+			}
+
+			s0 = bits.RotateLeft32(s0, 1) ^ xv[b0[i+32]] ^ xv[b0[i]]
+			s1 = bits.RotateLeft32(s1, 1) ^ xv[b1[i+32]] ^ xv[b1[i]]
+			s2 = bits.RotateLeft32(s2, 1) ^ xv[b2[i+32]] ^ xv[b2[i]]
+			s3 = bits.RotateLeft32(s3, 1) ^ xv[b3[i+32]] ^ xv[b3[i]]
+		}
+
+		from += multiLanes * span
+	}
+
+	return c.scanScalar(buf, c.preheat(buf, from), from, to)
+}
+
+func (c *buzhashChunker) scanScalar(buf []byte, state uint32, from, to int) int {
+	for from < to && (state&c.mask) != c.target {
+		state = bits.RotateLeft32(state, 1) ^ c.xv[buf[from]] ^ c.xv[buf[from-32]]
+		from++
+	}
+	return from
+}
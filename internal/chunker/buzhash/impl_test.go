@@ -0,0 +1,75 @@
+package buzhash
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/chunker"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func FuzzMultilaneBoundaries(f *testing.F) {
+
+	f.Add(int64(0), uint32(1<<20), uint8(0), uint8(10), uint16(64), uint32(0))
+	f.Add(int64(42), uint32(3<<20), uint8(0), uint8(17), uint16(2048), uint32(0))
+	f.Add(int64(7), uint32(1<<19), uint8(2), uint8(5), uint16(32), uint32(3))
+	f.Add(int64(-1), uint32(5000), uint8(16), uint8(8), uint16(33), uint32(0))
+
+	f.Fuzz(func(t *testing.T, seed int64, size uint32, alphabetBits uint8, maskBits uint8, minSize uint16, target uint32) {
+
+		size %= 4 << 20
+		maskBits = 5 + maskBits%18
+		if minSize < 32 {
+			minSize += 32
+		}
+		maxSize := int(minSize) + 1<<maskBits
+		if maxSize > 1<<20 {
+			maxSize = 1 << 20
+		}
+
+		data := make([]byte, size)
+		rnd := rand.New(rand.NewSource(seed))
+		rnd.Read(data) //nolint:errcheck
+
+		// low-entropy inputs trigger plenty of matches in all lanes at once
+		if alphabetBits %= 9; alphabetBits > 0 {
+			for i := range data {
+				data[i] &= byte(1<<alphabetBits - 1)
+			}
+		}
+
+		ch, _, errs := NewChunker([]string{
+			"buzhash",
+			"--hash-table=GoIPFSv0",
+			fmt.Sprintf("--state-target=%d", target&(1<<maskBits-1)),
+			fmt.Sprintf("--state-mask-bits=%d", maskBits),
+			fmt.Sprintf("--min-size=%d", minSize),
+			fmt.Sprintf("--max-size=%d", maxSize),
+		})
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		c := ch.(*buzhashChunker)
+
+		for _, useEntireBuffer := range []bool{true, false} {
+			c.multilane = false
+			expected := splitAll(t, c, data, useEntireBuffer)
+			c.multilane = true
+			actual := splitAll(t, c, data, useEntireBuffer)
+
+			if !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("multilane boundaries differ from scalar ones (useEntireBuffer:%t)\nscalar:    %v\nmultilane: %v", useEntireBuffer, expected, actual)
+			}
+		}
+	})
+}
+
+func splitAll(t *testing.T, c *buzhashChunker, data []byte, useEntireBuffer bool) (sizes []int) {
+	if err := c.Split(data, useEntireBuffer, func(r anlchunker.Chunk) error {
+		sizes = append(sizes, r.Size)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
@@ -2,8 +2,15 @@ package anlchunker
 
 import (
 	"github.com/anjor/anelace/internal/constants"
+	"github.com/klauspost/cpuid/v2"
 )
 
+// PreferMultilaneScan is consulted by the rolling-hash chunkers when choosing
+// between the classic byte-at-a-time boundary scan and an interleaved
+// multi-lane one producing identical boundaries. The latter only pays off on
+// wide out-of-order cores, which we approximate via the presence of AVX2/ASIMD
+var PreferMultilaneScan = cpuid.CPU.Supports(cpuid.AVX2) || cpuid.CPU.Supports(cpuid.ASIMD)
+
 type InstanceConstants struct {
 	_            constants.Incomparabe
 	MinChunkSize int
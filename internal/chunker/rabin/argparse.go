@@ -5,6 +5,8 @@ import (
 	"github.com/anjor/anelace/internal/chunker"
 	"github.com/anjor/anelace/internal/chunker/rabin/bootstrap"
 	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/keystream"
+	"strings"
 
 	"github.com/pborman/getopt/v2"
 	"github.com/pborman/options"
@@ -32,7 +34,12 @@ func NewChunker(
 		initErrs = argparser.SubHelp(
 			"Chunker based on the venerable 'Rabin Fingerprint', similar to the one\n"+
 				"used by `restic`, the LBFS, and others. The provided implementation is a\n"+
-				"significantly slimmed-down adaptation of multiple \"classic\" versions.",
+				"significantly slimmed-down adaptation of multiple \"classic\" versions.\n"+
+				"Instead of a fixed polynomial, an irreducible one can be generated from a\n"+
+				"seed. The seed is the secret key supplied via key-env or key-file: the same\n"+
+				"key always yields the same polynomial, and chunk boundaries can no longer\n"+
+				"be used to fingerprint content by anyone not holding it. Note: names/paths\n"+
+				"may not contain '_'.",
 			optSet,
 		)
 		return
@@ -49,6 +56,26 @@ func NewChunker(
 		)
 	}
 
+	var polySources []string
+	for _, o := range []string{"polynomial", "key-env", "key-file"} {
+		if optSet.IsSet(o) {
+			polySources = append(polySources, "'"+o+"'")
+		}
+	}
+
+	if len(polySources) > 1 {
+		initErrs = append(initErrs, fmt.Errorf(
+			"options %s are mutually exclusive",
+			strings.Join(polySources, ", "),
+		))
+	} else if optSet.IsSet("key-env") || optSet.IsSet("key-file") {
+		if key, err := keystream.LoadSecret(c.KeyEnv, c.KeyFile); err != nil {
+			initErrs = append(initErrs, err)
+		} else {
+			c.Polynomial = DerivePolynomial(key)
+		}
+	}
+
 	var err error
 	c.outTable, c.modTable, err = bootstrap.GenerateLookupTables(c.Polynomial, c.WindowSize)
 	if err != nil {
@@ -65,9 +92,21 @@ func NewChunker(
 	// but derive it longform nevertheless
 	c.initState = ((c.outTable[0] << 8) | 1) ^ (c.modTable[c.outTable[0]>>bootstrap.DegShift])
 	c.minSansPreheat = c.MinSize - c.WindowSize
+	c.multilane = anlchunker.PreferMultilaneScan && c.minSansPreheat >= 0
 
 	return &c, anlchunker.InstanceConstants{
 		MinChunkSize: c.MinSize,
 		MaxChunkSize: c.MaxSize,
 	}, initErrs
 }
+
+// DerivePolynomial deterministically picks an irreducible polynomial based on
+// the supplied secret
+func DerivePolynomial(secret []byte) uint64 {
+	pol, err := bootstrap.RandomIrreducible(keystream.New(secret, "anelace rabin polynomial"))
+	if err != nil {
+		// can not happen: the keystream is endless
+		panic(err)
+	}
+	return pol
+}
@@ -1,7 +1,9 @@
 package bootstrap
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
 	"math/bits"
 )
 
@@ -64,6 +66,70 @@ func GenerateLookupTables(pol uint64, wSize int) (outTable, modTable [256]uint64
 	return
 }
 
+// RandomIrreducible draws candidate polynomials of the expected degree from
+// the supplied source until it finds an irreducible one. Roughly 1 in 27 of
+// the candidates (constant term always set) qualifies.
+func RandomIrreducible(src io.Reader) (uint64, error) {
+	var buf [8]byte
+	for i := 0; i < 1e6; i++ {
+		if _, err := io.ReadFull(src, buf[:]); err != nil {
+			return 0, err
+		}
+		pol := binary.BigEndian.Uint64(buf[:])
+
+		// force degree and a non-zero constant term, otherwise x is a factor
+		pol &= (1 << degTarget) - 1
+		pol |= (1 << degTarget) | 1
+
+		if Irreducible(pol) {
+			return pol, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to find an irreducible polynomial of degree %d, is the random source broken?", degTarget)
+}
+
+// Irreducible reports whether pol has no factors over GF(2) other than 1 and
+// itself. Uses Ben-Or's test: pol of degree d is irreducible iff for every
+// i in [1:d/2] gcd( pol, x^(2^i) - x ) == 1
+func Irreducible(pol uint64) bool {
+	d := deg(pol)
+	if d < 1 {
+		return false
+	}
+	// x^(2^i) mod pol, starting with x itself
+	xPow := mod(2, pol)
+	for i := 1; i <= d/2; i++ {
+		xPow = mulMod(xPow, xPow, pol)
+		if gcd(pol, xPow^mod(2, pol)) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// the product a*b mod pol, both a and b must already be reduced by pol
+func mulMod(a, b, pol uint64) (res uint64) {
+	d := uint(deg(pol))
+	for b != 0 {
+		if b&1 != 0 {
+			res ^= a
+		}
+		b >>= 1
+		a <<= 1
+		if a&(1<<d) != 0 {
+			a ^= pol
+		}
+	}
+	return
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, mod(a, b)
+	}
+	return a
+}
+
 // the degree of the polynomial pol. If pol is zero, -1 is returned.
 func deg(pol uint64) int {
 	return bits.Len64(pol) - 1
@@ -0,0 +1,43 @@
+package bootstrap
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestIrreducible(t *testing.T) {
+
+	// the go-ipfs default
+	if !Irreducible(17437180132763653) {
+		t.Error("the go-ipfs default polynomial not considered irreducible")
+	}
+
+	// (x^2 + x + 1) * (x^51 + x + 1)
+	var product uint64
+	for _, shift := range []uint{0, 1, 2} {
+		product ^= ((1 << 51) | 2 | 1) << shift
+	}
+	if deg(product) != int(degTarget) {
+		t.Fatalf("unexpected degree %d of test product", deg(product))
+	}
+	if Irreducible(product) {
+		t.Errorf("polynomial %d considered irreducible despite being a product", product)
+	}
+
+	seed := time.Now().UnixNano()
+	pol, err := RandomIrreducible(rand.New(rand.NewSource(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := GenerateLookupTables(pol, 16); err != nil {
+		t.Errorf("generated polynomial %d (seed %d) rejected: %s", pol, seed, err)
+	}
+
+	// partial cross-check against trial division by all low-degree polynomials
+	for div := uint64(2); div < 1<<21; div++ {
+		if mod(pol, div) == 0 {
+			t.Fatalf("generated polynomial %d (seed %d) is divisible by %d", pol, seed, div)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 type config struct {
 	Polynomial  uint64 `getopt:"--polynomial=uint64      (IPFS default: 17437180132763653)"`
+	KeyEnv      string `getopt:"--key-env=varname        Derive the polynomial from a seed: the secret key held in this environment variable. Boundaries become unpredictable without the key"`
+	KeyFile     string `getopt:"--key-file=path          Derive the polynomial from a seed: the secret key read from this file. Boundaries become unpredictable without the key"`
 	TargetValue uint64 `getopt:"--state-target=uint64    State value denoting a chunk boundary (IPFS default: 0)"`
 	MaskBits    int    `getopt:"--state-mask-bits=[5:22] Amount of bits of state to compare to target on every iteration. For random input average chunk size is about 2**m (IPFS default: 18)"`
 	WindowSize  int    `getopt:"--window-size=bytes    State value denoting a chunk boundary (IPFS default: 16)"`
@@ -19,6 +21,7 @@ type rabinChunker struct {
 	initState      uint64
 	mask           uint64
 	minSansPreheat int
+	multilane      bool
 	outTable       [256]uint64
 	modTable       [256]uint64
 	config
@@ -56,6 +59,15 @@ func (c *rabinChunker) Split(
 			return
 		}
 
+		if c.multilane {
+			curIdx = c.scanMultilane(buf, curIdx+c.MinSize, nextRoundMax)
+			err = cb(anlchunker.Chunk{Size: curIdx - lastIdx})
+			if err != nil {
+				return
+			}
+			continue
+		}
+
 		// reset
 		state = c.initState
 
@@ -0,0 +1,100 @@
+package rabin
+
+import (
+	"github.com/anjor/anelace/internal/chunker/rabin/bootstrap"
+)
+
+// The state is reduced modulo the polynomial after every byte, and the byte
+// leaving the window is cancelled out exactly. Thus the state at any offset
+// is a pure function of the preceding window, and can be computed for several
+// far-apart offsets at once. We run multiLanes independent rolling states over
+// adjacent spans of the buffer, interleaved in a single loop so that the CPU
+// can overlap the otherwise strictly sequential table lookups.
+const (
+	multiLanes       = 4
+	multiLaneMaxSpan = 4096
+	multiLaneMinSpan = 256
+)
+
+func (c *rabinChunker) preheat(buf []byte, idx int) (state uint64) {
+	state = c.initState
+	for i := 1; i <= c.WindowSize; i++ {
+		if i == c.WindowSize {
+			state ^= c.outTable[1]
+		} else {
+			state ^= c.outTable[0]
+		}
+		state = (state << 8) | uint64(buf[idx-c.WindowSize+i-1]) ^ (c.modTable[state>>bootstrap.DegShift])
+	}
+	return
+}
+
+// Returns the first index within [from:to) at which the state of the
+// preceding window matches the target, or `to` if there is none. Result
+// is identical to what the byte-at-a-time cycle in Split() arrives at.
+func (c *rabinChunker) scanMultilane(buf []byte, from, to int) int {
+
+	mask, target, w := c.mask, c.TargetValue, c.WindowSize
+
+	for to-from >= multiLanes*multiLaneMinSpan {
+
+		span := (to - from) / multiLanes
+		if span > multiLaneMaxSpan {
+			span = multiLaneMaxSpan
+		}
+
+		p0, p1, p2, p3 := from, from+span, from+2*span, from+3*span
+		s0, s1, s2, s3 := c.preheat(buf, p0), c.preheat(buf, p1), c.preheat(buf, p2), c.preheat(buf, p3)
+
+		// pre-sliced lanes let the compiler drop most bounds checks in the loop
+		outT, modT := &c.outTable, &c.modTable
+		b0, b1, b2, b3 := buf[p0-w:p0+span], buf[p1-w:p1+span], buf[p2-w:p2+span], buf[p3-w:p3+span]
+
+		for i := 0; i < span; i++ {
+
+			if (s0&mask) == target ||
+				(s1&mask) == target ||
+				(s2&mask) == target ||
+				(s3&mask) == target {
+
+				// Something matched: the lowest matching lane provides a candidate,
+				// but any lane before it may still match further along its span
+				lanes := [multiLanes]struct {
+					pos   int
+					state uint64
+				}{{p0 + i, s0}, {p1 + i, s1}, {p2 + i, s2}, {p3 + i, s3}}
+
+				for l := range lanes {
+					if (lanes[l].state & mask) == target {
+						return lanes[l].pos
+					}
+					if found := c.scanScalar(buf, lanes[l].state, lanes[l].pos, from+(l+1)*span); found < from+(l+1)*span {
+						return found
+					}
+				}
+			}
+
+			s0 ^= outT[b0[i]]
+			s1 ^= outT[b1[i]]
+			s2 ^= outT[b2[i]]
+			s3 ^= outT[b3[i]]
+			s0 = (s0 << 8) | uint64(b0[i+w]) ^ (modT[s0>>bootstrap.DegShift])
+			s1 = (s1 << 8) | uint64(b1[i+w]) ^ (modT[s1>>bootstrap.DegShift])
+			s2 = (s2 << 8) | uint64(b2[i+w]) ^ (modT[s2>>bootstrap.DegShift])
+			s3 = (s3 << 8) | uint64(b3[i+w]) ^ (modT[s3>>bootstrap.DegShift])
+		}
+
+		from += multiLanes * span
+	}
+
+	return c.scanScalar(buf, c.preheat(buf, from), from, to)
+}
+
+func (c *rabinChunker) scanScalar(buf []byte, state uint64, from, to int) int {
+	for from < to && (state&c.mask) != c.TargetValue {
+		state ^= c.outTable[buf[from-c.WindowSize]]
+		state = (state << 8) | uint64(buf[from]) ^ (c.modTable[state>>bootstrap.DegShift])
+		from++
+	}
+	return from
+}
@@ -0,0 +1,54 @@
+package prolly
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/collector"
+	"github.com/anjor/anelace/internal/util/argparser"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/pborman/options"
+)
+
+func NewCollector(args []string, anlCfg *anlcollector.AnlConfig) (_ anlcollector.Collector, initErrs []error) {
+
+	co := &collector{
+		AnlConfig: anlCfg,
+		state:     newState(),
+	}
+
+	optSet := getopt.New()
+	if err := options.RegisterSet("", &co.config, optSet); err != nil {
+		initErrs = []error{fmt.Errorf("option set registration failed: %s", err)}
+		return
+	}
+
+	// on nil-args the "error" is the help text to be incorporated into
+	// the larger help display
+	if args == nil {
+		initErrs = argparser.SubHelp(
+			"Forms a DAG in the style of a prolly tree, where node boundaries are chosen\n"+
+				"by hashing the CID of every child, instead of by count or by size. Coupled\n"+
+				"with a content-defined chunker, an insertion or deletion within a stream only\n"+
+				"changes O(log n) link nodes, keeping successive versions deduplicated.",
+			optSet,
+		)
+		return
+	}
+
+	// bail early if getopt fails
+	if initErrs = argparser.Parse(args, optSet); len(initErrs) > 0 {
+		return
+	}
+
+	if !optSet.IsSet("max-outdegree") {
+		co.MaxOutdegree = 4 * co.TargetOutdegree
+	} else if co.MaxOutdegree < co.TargetOutdegree {
+		initErrs = append(initErrs, fmt.Errorf(
+			"value '%d' supplied for max-outdegree can not be lower than target-outdegree '%d'",
+			co.MaxOutdegree,
+			co.TargetOutdegree,
+		))
+	}
+
+	return co, initErrs
+}
@@ -0,0 +1,115 @@
+package prolly
+
+import (
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/collector"
+
+	"github.com/twmb/murmur3"
+)
+
+type config struct {
+	TargetOutdegree int `getopt:"--target-outdegree=[2:]  Average outdegree (children) for a node: a node is sealed after any child whose CID hashes to 0 modulo this value"`
+	MaxOutdegree    int `getopt:"--max-outdegree=integer  Maximum outdegree for a node, sealing it regardless of content. Default: 4 * target-outdegree"`
+}
+type state struct {
+	stack   [][]*anlblock.Header // the children of the open node of every layer
+	pending [][]*anlblock.Header // appended children not yet evaluated as a boundary
+}
+
+// The boundary test needs the CID of a child, which async hashers compute in
+// the background. Evaluating it right on append would wait for every block
+// in turn, hashing one at a time. Instead this many children per layer are
+// held back, and are hashed by the time they are evaluated. The constant
+// (rather than readiness-driven) delay keeps the car stream order identical
+// regardless of the amount of hashers.
+const sealLookahead = 256
+
+type collector struct {
+	config
+	*anlcollector.AnlConfig
+	state
+}
+
+func (co *collector) FlushState() *anlblock.Header {
+	if len(co.stack) == 1 && len(co.stack[0]) == 0 && len(co.pending[0]) == 0 {
+		return nil
+	}
+
+	// it is critical to reset the collector state when we are done - we reuse the object!
+	defer func() { co.state = newState() }()
+
+	for layerIdx := 0; ; layerIdx++ {
+		for len(co.pending[layerIdx]) > 0 {
+			co.evaluateNext(layerIdx)
+		}
+		layer := co.stack[layerIdx]
+
+		if layerIdx == len(co.stack)-1 && len(layer) == 1 {
+			return layer[0]
+		}
+
+		if len(layer) == 0 {
+			continue
+		}
+
+		// a lone remainder is not worth a node of its own: move it up as-is
+		co.stack[layerIdx] = layer[:0]
+		if len(layer) == 1 {
+			co.appendAt(layerIdx+1, layer[0])
+		} else {
+			co.appendAt(layerIdx+1, co.NodeEncoder.NewLink(layer))
+		}
+	}
+}
+
+func (co *collector) AppendData(ds anlblock.DataSource) (hdr *anlblock.Header) {
+	hdr = co.NodeEncoder.NewLeaf(ds)
+	co.AppendBlock(hdr)
+	return
+}
+
+func (co *collector) AppendBlock(hdr *anlblock.Header) {
+	co.appendAt(0, hdr)
+}
+
+func newState() state {
+	return state{
+		stack:   [][]*anlblock.Header{{}},
+		pending: [][]*anlblock.Header{{}},
+	}
+}
+
+func (co *collector) appendAt(layerIdx int, hdr *anlblock.Header) {
+
+	if layerIdx == len(co.stack) {
+		co.stack = append(co.stack, make([]*anlblock.Header, 0, co.TargetOutdegree))
+		co.pending = append(co.pending, make([]*anlblock.Header, 0, sealLookahead+1))
+	}
+	co.pending[layerIdx] = append(co.pending[layerIdx], hdr)
+
+	if len(co.pending[layerIdx]) > sealLookahead {
+		co.evaluateNext(layerIdx)
+	}
+}
+
+// Whether a node is sealed depends only on the child just evaluated (and how
+// many precede it since the last seal). An edit thus only reshapes the nodes
+// covering it, and their ancestors, instead of shifting every node after it.
+func (co *collector) evaluateNext(layerIdx int) {
+
+	pending := co.pending[layerIdx]
+	hdr := pending[0]
+	// shifting in place keeps reusing the same allocation
+	co.pending[layerIdx] = pending[:copy(pending, pending[1:])]
+
+	co.stack[layerIdx] = append(co.stack[layerIdx], hdr)
+	layer := co.stack[layerIdx]
+
+	if len(layer) >= co.MaxOutdegree ||
+		len(layer) > 1 && murmur3.Sum64(hdr.Cid())%uint64(co.TargetOutdegree) == 0 {
+
+		// NewLink() does not retain the slice, reuse it
+		co.stack[layerIdx] = layer[:0]
+		co.appendAt(layerIdx+1, co.NodeEncoder.NewLink(layer))
+	}
+}
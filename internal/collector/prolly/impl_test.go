@@ -0,0 +1,124 @@
+package prolly
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/collector"
+	"github.com/anjor/anelace/internal/encoder"
+	"github.com/anjor/anelace/internal/encoder/unixfsv1"
+	"github.com/anjor/anelace/internal/util/zcpstring"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestEditStability(t *testing.T) {
+
+	maker, _, err := anlblock.MakerFromConfig("sha2-256", 32, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(leaves []string) (root *anlblock.Header, links map[string]struct{}) {
+		links = make(map[string]struct{})
+		enc, errs := unixfsv1.NewEncoder(
+			[]string{"unixfsv1"},
+			&anlencoder.AnlConfig{
+				BlockMaker:           maker,
+				HasherName:           "sha2-256",
+				HasherBits:           256,
+				NewLinkBlockCallback: func(h *anlblock.Header) { links[string(h.Cid())] = struct{}{} },
+			},
+		)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		co, errs := NewCollector(
+			[]string{"prolly", "--target-outdegree=4"},
+			&anlcollector.AnlConfig{NodeEncoder: enc},
+		)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+
+		for _, l := range leaves {
+			ds := anlblock.DataSource{Content: zcpstring.WrapSlice([]byte(l))}
+			ds.Size = len(l)
+			co.AppendData(ds)
+		}
+		return co.FlushState(), links
+	}
+
+	var leaves []string
+	for i := 0; i < 5000; i++ {
+		leaves = append(leaves, fmt.Sprintf("leaf #%d", i))
+	}
+	root, links := build(leaves)
+	if root.SizeCumulativePayload() != 5000*uint64(len("leaf #"))+4*(5000-1000)+3*900+2*90+10 {
+		t.Fatalf("unexpected payload size %d", root.SizeCumulativePayload())
+	}
+
+	edited, editedLinks := build(append(append(append([]string{}, leaves[:10]...), "inserted"), leaves[10:]...))
+	if string(root.Cid()) == string(edited.Cid()) {
+		t.Fatal("insertion did not change the root")
+	}
+
+	var changed int
+	for l := range editedLinks {
+		if _, existed := links[l]; !existed {
+			changed++
+		}
+	}
+
+	// a handful per layer, out of well over a thousand
+	if changed > 20 {
+		t.Errorf("insertion of a single leaf changed %d out of %d link nodes", changed, len(editedLinks))
+	}
+}
+
+// With async hashers the collector must not wait on each leaf in turn
+func BenchmarkAsyncHashing(b *testing.B) {
+
+	leaves := make([][]byte, 512)
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := range leaves {
+		leaves[i] = make([]byte, 64*1024)
+		rnd.Read(leaves[i]) //nolint:errcheck
+	}
+
+	for _, hashers := range []int{0, 4} {
+		b.Run(fmt.Sprintf("hashers=%d", hashers), func(b *testing.B) {
+
+			maker, bus, err := anlblock.MakerFromConfig("sha2-256", 32, 0, hashers, 8*1024*1024, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer bus.Close()
+
+			enc, errs := unixfsv1.NewEncoder([]string{"unixfsv1"}, &anlencoder.AnlConfig{
+				BlockMaker:           maker,
+				HasherName:           "sha2-256",
+				HasherBits:           256,
+				NewLinkBlockCallback: func(*anlblock.Header) {},
+			})
+			if len(errs) > 0 {
+				b.Fatal(errs)
+			}
+			co, errs := NewCollector([]string{"prolly", "--target-outdegree=16"}, &anlcollector.AnlConfig{NodeEncoder: enc})
+			if len(errs) > 0 {
+				b.Fatal(errs)
+			}
+
+			b.SetBytes(int64(len(leaves) * len(leaves[0])))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, l := range leaves {
+					ds := anlblock.DataSource{Content: zcpstring.WrapSlice(l)}
+					ds.Size = len(l)
+					co.AppendData(ds)
+				}
+				co.FlushState().Cid()
+			}
+		})
+	}
+}
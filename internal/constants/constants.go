@@ -9,6 +9,10 @@ const (
 	// https://github.com/ipfs/go-ipfs-chunker/pull/21#discussion_r369197120
 	MaxLeafPayloadSize = 1024 * 1024
 	MaxBlockWireSize   = (2 * 1024 * 1024) - 1
+
+	// Recorded in every summary, and checked on --replay-from. Must be bumped
+	// by any change resulting in different CIDs for an unchanged argvExpanded
+	CidCompatVersion = 1
 )
 
 type Incomparabe [0]func()
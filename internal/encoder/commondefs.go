@@ -2,6 +2,7 @@ package anlencoder
 
 import (
 	"github.com/anjor/anelace/internal/block"
+	"time"
 )
 
 type NodeEncoder interface {
@@ -9,6 +10,34 @@ type NodeEncoder interface {
 	NewLink(blocksToLink []*anlblock.Header) (linkBlock *anlblock.Header)
 }
 
+// Optionally implemented by encoders which can link via legacy CIDv0 references,
+// so that the roots they produce can be rendered in the bare `Qm...` form
+type CIDv0Linker interface {
+	LinksCIDv0() bool
+}
+
+// Optionally implemented by encoders which can represent a symbolic link. The
+// resulting block is not reported through NewLinkBlockCallback.
+type SymlinkEncoder interface {
+	NewSymlink(target string) (symlinkBlock *anlblock.Header)
+}
+
+// Optionally implemented by encoders which can record file metadata on the
+// root node of a stream. DecorateRoot is invoked on a root that is not yet
+// emitted: it either returns a replacement for it, or a new link block over
+// it reported through NewLinkBlockCallback as usual.
+type RootDecorator interface {
+	PreservesRootMetadata() bool
+	DecorateRoot(root *anlblock.Header, meta RootMetadata) (decoratedRoot *anlblock.Header)
+}
+
+// RootMetadata is what is known about the source of a stream
+type RootMetadata struct {
+	Mode    uint32 // unix permission bits, including setuid/setgid/sticky
+	HasMode bool
+	Mtime   time.Time // zero when unknown
+}
+
 type Initializer func(
 	encoderCLISubArgs []string,
 	acfg *AnlConfig,
@@ -5,6 +5,7 @@ import (
 	"github.com/anjor/anelace/internal/encoder"
 	"github.com/anjor/anelace/internal/util/argparser"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pborman/getopt/v2"
 	"github.com/pborman/options"
 )
@@ -52,5 +53,19 @@ func NewEncoder(args []string, cfg *anlencoder.AnlConfig) (_ anlencoder.NodeEnco
 		)
 	}
 
+	if e.ZstdLeaves {
+		if e.UnixFsType != -1 {
+			initErrs = append(initErrs, fmt.Errorf("option 'experimental-zstd-leaves' can only be used with raw leaves"))
+		}
+		if e.LegacyCIDv0Links {
+			initErrs = append(initErrs, fmt.Errorf("option 'experimental-zstd-leaves' can not be combined with 'cidv0'"))
+		}
+
+		var err error
+		if e.zstdEncoder, err = zstd.NewWriter(nil); err != nil {
+			initErrs = append(initErrs, fmt.Errorf("zstd initialization failed: %s", err))
+		}
+	}
+
 	return e, initErrs
 }
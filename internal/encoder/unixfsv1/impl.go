@@ -1,10 +1,14 @@
 package unixfsv1
 
 import (
+	"encoding/binary"
 	"github.com/anjor/anelace/internal/block"
 	"github.com/anjor/anelace/internal/encoder"
 	"github.com/anjor/anelace/internal/util/encoding"
 	"github.com/anjor/anelace/internal/util/zcpstring"
+	"log"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 type config struct {
@@ -12,15 +16,43 @@ type config struct {
 	LegacyCIDv0Links     bool `getopt:"--cidv0                      Generate compat-mode CIDv0 links"`
 	NonstandardLeanLinks bool `getopt:"--non-standard-lean-links    Omit dag-size and offset information from all links. While IPFS will likely render the result, ONE VOIDS ALL WARRANTIES"`
 	UnixFsType           int  `getopt:"--unixfs-leaf-decorator-type Generate leaves as full UnixFS nodes with the given UnixFSv1 type (0 or 2). When unspecified (default) uses raw leaves instead."`
+	ZstdLeaves           bool `getopt:"--experimental-zstd-leaves   Store every raw leaf compressible by zstd in its compressed form, prefixed by a marker. For measuring potential savings: NOTHING ELSE CAN READ THE RESULT"`
+	PreserveMode         bool `getopt:"--preserve-mode              Record the permission bits of the source file (UnixFS 1.5 'mode') on the root node, when known. A root which would be a raw leaf is wrapped in a single-link file node"`
+	PreserveMtime        bool `getopt:"--preserve-mtime             Record the modification time of the source file (UnixFS 1.5 'mtime') on the root node, when known. A root which would be a raw leaf is wrapped in a single-link file node"`
 }
 
 type encoder struct {
 	config
 	*anlencoder.AnlConfig
+	zstdEncoder *zstd.Encoder
 }
 
+// A zstd skippable frame, so that every compressed leaf is still a valid zstd
+// stream on its own, yet clearly distinguishable from an uncompressed leaf
+// that merely happens to begin with the zstd magic
+var ZstdLeafMarker = []byte("\x5A\x2A\x4D\x18" + "\x14\x00\x00\x00" + "anelace/zstd-leaf/v1")
+
+func (e *encoder) LinksCIDv0() bool { return e.LegacyCIDv0Links }
+
 func (e *encoder) NewLeaf(ds anlblock.DataSource) *anlblock.Header {
 
+	if e.zstdEncoder != nil && ds.Size > 0 {
+		compressed := e.zstdEncoder.EncodeAll(
+			ds.Content.AppendTo(make([]byte, 0, ds.Size)),
+			append(make([]byte, 0, ds.Size), ZstdLeafMarker...),
+		)
+
+		// keep only what actually got smaller
+		if len(compressed) < ds.Size {
+			return e.BlockMaker(
+				zcpstring.WrapSlice(compressed),
+				anlblock.CodecRaw,
+				uint64(ds.Size),
+				0,
+			)
+		}
+	}
+
 	if e.UnixFsType == -1 {
 		return e.BlockMaker(
 			ds.Content,
@@ -57,6 +89,11 @@ func (e *encoder) NewLeaf(ds anlblock.DataSource) *anlblock.Header {
 }
 
 func (e *encoder) NewLink(blocks []*anlblock.Header) *anlblock.Header {
+	return e.newLink(blocks, nil)
+}
+
+// ufsMeta are extra pre-encoded fields appended to the UnixFS data section
+func (e *encoder) newLink(blocks []*anlblock.Header, ufsMeta []byte) *anlblock.Header {
 
 	// special-case compat bullshit
 	if blocks == nil {
@@ -131,13 +168,16 @@ func (e *encoder) NewLink(blocks []*anlblock.Header) *anlblock.Header {
 	}
 
 	linkBlock.AddByte(pbHdrF1LD)
-	linkBlock.AddSlice(encoding.VarintSlice(uint64(3 + len(payloadSizeVI) + seekOffsets.Size())))
+	linkBlock.AddSlice(encoding.VarintSlice(uint64(3 + len(payloadSizeVI) + seekOffsets.Size() + len(ufsMeta))))
 
 	linkBlock.AddByte(pbHdrF1VI)
 	linkBlock.AddByte(2)
 	linkBlock.AddByte(pbHdrF3VI)
 	linkBlock.AddSlice(payloadSizeVI)
 	linkBlock.AddZcp(seekOffsets)
+	if len(ufsMeta) > 0 {
+		linkBlock.AddSlice(ufsMeta)
+	}
 
 	if !e.CompatPb {
 		linkBlock.AddZcp(linkSection)
@@ -154,6 +194,108 @@ func (e *encoder) NewLink(blocks []*anlblock.Header) *anlblock.Header {
 	return h
 }
 
+// represents the protobuf
+//
+//	1 {
+//		1: 4
+//		2: target
+//	}
+func (e *encoder) NewSymlink(target string) *anlblock.Header {
+
+	targetLenVI := encoding.VarintSlice(uint64(len(target)))
+
+	blockData := zcpstring.NewWithSegmentCap(6)
+	blockData.AddByte(pbHdrF1LD)
+	blockData.AddSlice(encoding.VarintSlice(uint64(3 + len(targetLenVI) + len(target))))
+	blockData.AddByte(pbHdrF1VI)
+	blockData.AddByte(4)
+	blockData.AddByte(pbHdrF2LD)
+	blockData.AddSlice(targetLenVI)
+	blockData.AddSlice([]byte(target))
+
+	return e.BlockMaker(
+		blockData,
+		anlblock.CodecPB,
+		0,
+		0,
+	)
+}
+
+func (e *encoder) PreservesRootMetadata() bool { return e.PreserveMode || e.PreserveMtime }
+
+func (e *encoder) DecorateRoot(root *anlblock.Header, meta anlencoder.RootMetadata) *anlblock.Header {
+
+	ufsMeta := e.encodeMetadata(meta)
+	if ufsMeta == nil {
+		return root
+	}
+
+	// A raw leaf has nowhere to put it. Whether kubo wraps single-chunk files
+	// the same way is pinned by maint/misc/kubo_metadata_convergence.tsv
+	if root.Cid()[1] != byte(anlblock.CodecPB) {
+		return e.newLink([]*anlblock.Header{root}, ufsMeta)
+	}
+
+	// Otherwise append to the UnixFS data section: fields 7 and 8 sort after
+	// anything already in it. Everything else (and its order) stays intact
+	pb := root.Content().AppendTo(make([]byte, 0, root.SizeBlock()))
+	decorated := zcpstring.NewWithSegmentCap(8)
+	for len(pb) > 0 {
+		key := pb[0]
+		fieldLen, vl := binary.Uvarint(pb[1:])
+		if vl <= 0 || key&7 != 2 || uint64(len(pb)-1-vl) < fieldLen {
+			log.Panicf("unexpected dag-pb root content %x", root.Content().AppendTo(nil))
+		}
+		field := pb[1+vl : 1+vl+int(fieldLen)]
+		pb = pb[1+vl+int(fieldLen):]
+
+		decorated.AddByte(key)
+		if key == pbHdrF1LD {
+			decorated.AddSlice(encoding.VarintSlice(fieldLen + uint64(len(ufsMeta))))
+			decorated.AddSlice(field)
+			decorated.AddSlice(ufsMeta)
+		} else {
+			decorated.AddSlice(encoding.VarintSlice(fieldLen))
+			decorated.AddSlice(field)
+		}
+	}
+
+	return e.BlockMaker(
+		decorated,
+		anlblock.CodecPB,
+		root.SizeCumulativePayload(),
+		root.SizeCumulativeDag()-uint64(root.SizeBlock()),
+	)
+}
+
+// encodeMetadata renders the UnixFS 1.5 fields in the order of the spec:
+//
+//	7: mode
+//	8 {
+//		1: seconds
+//		2: fractional nanoseconds (fixed32, only when non-zero)
+//	}
+func (e *encoder) encodeMetadata(meta anlencoder.RootMetadata) (ufsMeta []byte) {
+
+	if e.PreserveMode && meta.HasMode {
+		ufsMeta = append(ufsMeta, pbHdrF7VI)
+		ufsMeta = encoding.AppendVarint(ufsMeta, uint64(meta.Mode))
+	}
+
+	if e.PreserveMtime && !meta.Mtime.IsZero() {
+		mtime := append([]byte{pbHdrF1VI}, encoding.VarintSlice(uint64(meta.Mtime.Unix()))...)
+		if ns := meta.Mtime.Nanosecond(); ns > 0 {
+			mtime = append(mtime, pbHdrF2F32, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(mtime[len(mtime)-4:], uint32(ns))
+		}
+		ufsMeta = append(ufsMeta, pbHdrF8LD)
+		ufsMeta = encoding.AppendVarint(ufsMeta, uint64(len(mtime)))
+		ufsMeta = append(ufsMeta, mtime...)
+	}
+
+	return
+}
+
 // represents the protobuf
 //
 //	1 {
@@ -184,3 +326,8 @@ const (
 	pbHdrF3LD
 	pbHdrF4LD
 )
+const (
+	pbHdrF7VI  = 0 | (7 << 3)
+	pbHdrF8LD  = 2 | (8 << 3)
+	pbHdrF2F32 = 5 | (2 << 3)
+)
@@ -0,0 +1,120 @@
+package unixfsv1
+
+import (
+	"bytes"
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/chunker"
+	"github.com/anjor/anelace/internal/encoder"
+	"github.com/anjor/anelace/internal/util/zcpstring"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdLeaves(t *testing.T) {
+
+	maker, _, err := anlblock.MakerFromConfig("sha2-256", 32, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, errs := NewEncoder(
+		[]string{"unixfsv1", "--experimental-zstd-leaves"},
+		&anlencoder.AnlConfig{BlockMaker: maker, HasherName: "sha2-256", HasherBits: 256},
+	)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	seed := time.Now().UnixNano()
+	random := make([]byte, 256*1024)
+	rand.New(rand.NewSource(seed)).Read(random) //nolint:errcheck
+	compressible := bytes.Repeat([]byte("compressible "), 20000)
+
+	// random data can not shrink: stored as-is
+	hdr := enc.NewLeaf(anlblock.DataSource{Chunk: anlchunker.Chunk{Size: len(random)}, Content: zcpstring.WrapSlice(random)})
+	if !bytes.Equal(hdr.Content().AppendTo(nil), random) {
+		t.Errorf("incompressible leaf not stored verbatim (seed %d)", seed)
+	}
+
+	hdr = enc.NewLeaf(anlblock.DataSource{Chunk: anlchunker.Chunk{Size: len(compressible)}, Content: zcpstring.WrapSlice(compressible)})
+	stored := hdr.Content().AppendTo(nil)
+	if !bytes.HasPrefix(stored, ZstdLeafMarker) || len(stored) >= len(compressible) {
+		t.Fatalf("compressible leaf of %d bytes stored as %d bytes without the expected marker", len(compressible), len(stored))
+	}
+	if hdr.SizeCumulativePayload() != uint64(len(compressible)) {
+		t.Errorf("payload size %d of compressed leaf does not match the original %d", hdr.SizeCumulativePayload(), len(compressible))
+	}
+
+	// a stock decoder must skip over the marker
+	dec, _ := zstd.NewReader(nil)
+	defer dec.Close()
+	if plain, err := dec.DecodeAll(stored, nil); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(plain, compressible) {
+		t.Error("compressed leaf does not decompress to the original content")
+	}
+}
+
+func TestDecorateRoot(t *testing.T) {
+
+	maker, _, err := anlblock.MakerFromConfig("sha2-256", 32, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var linked []*anlblock.Header
+	enc, errs := NewEncoder(
+		[]string{"unixfsv1", "--preserve-mode", "--preserve-mtime"},
+		&anlencoder.AnlConfig{
+			BlockMaker:           maker,
+			HasherName:           "sha2-256",
+			HasherBits:           256,
+			NewLinkBlockCallback: func(h *anlblock.Header) { linked = append(linked, h) },
+		},
+	)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	dec := enc.(anlencoder.RootDecorator)
+
+	meta := anlencoder.RootMetadata{
+		Mode:    0644,
+		HasMode: true,
+		Mtime:   time.Unix(1600000000, 123),
+	}
+
+	// nothing known: nothing changes
+	leaf := enc.NewLeaf(anlblock.DataSource{Chunk: anlchunker.Chunk{Size: 5}, Content: zcpstring.WrapSlice([]byte("hello"))})
+	if dec.DecorateRoot(leaf, anlencoder.RootMetadata{}) != leaf {
+		t.Error("root without any metadata was not left as-is")
+	}
+
+	// a raw leaf gets wrapped
+	root := dec.DecorateRoot(leaf, meta)
+	if len(linked) != 1 || linked[0] != root {
+		t.Fatal("wrapping link block was not reported through the callback")
+	}
+	expected := append(
+		[]byte("\x0a\x16"+
+			"\x08\x02\x18\x05\x20\x05"+
+			"\x38\xa4\x03"+
+			"\x42\x0b\x08\x80\xa0\xf8\xfa\x05\x15\x7b\x00\x00\x00"+
+			"\x12\x2a\x0a\x24"),
+		leaf.Cid()...,
+	)
+	expected = append(expected, "\x12\x00\x18\x05"...)
+	if got := root.Content().AppendTo(nil); !bytes.Equal(got, expected) {
+		t.Errorf("wrapped root\n%x\nexpected\n%x", got, expected)
+	}
+	if root.SizeCumulativePayload() != 5 || root.SizeCumulativeDag() != uint64(5+len(expected)) {
+		t.Errorf("unexpected payload/dag sizes %d/%d", root.SizeCumulativePayload(), root.SizeCumulativeDag())
+	}
+
+	// a dag-pb root gets its data section extended in place
+	nul := enc.NewLink(nil)
+	root = dec.DecorateRoot(nul, anlencoder.RootMetadata{Mode: 0755, HasMode: true})
+	if got := root.Content().AppendTo(nil); !bytes.Equal(got, []byte("\x0a\x07\x08\x02\x18\x00\x38\xed\x03")) {
+		t.Errorf("unexpected decorated nul root %x", got)
+	}
+}
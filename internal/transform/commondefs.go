@@ -0,0 +1,32 @@
+package anltransform
+
+import (
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/encoder"
+)
+
+// A Transform sits between the collector chain and the actual NodeEncoder:
+// it receives the chunked leaf data before it is encoded, and gets to see
+// every set of blocks that is about to be linked together.
+type Transform interface {
+	anlencoder.NodeEncoder
+
+	// Returns the key needed to make sense of the DAG under root and, when
+	// the root is a link node, the block carrying the (encrypted) keys of
+	// everything below it. Valid only once per root, after FlushState().
+	RootKey(root *anlblock.Header) (key []byte, keyManifest *anlblock.Header)
+}
+
+type Initializer func(
+	transformCLISubArgs []string,
+	acfg *AnlConfig,
+) (instance Transform, initErrorStrings []error)
+
+type AnlConfig struct {
+	NodeEncoder anlencoder.NodeEncoder
+	BlockMaker  anlblock.Maker
+
+	// Receives every block a transform forms on its own, in place of the
+	// NodeEncoder's NewLinkBlockCallback: these are not part of the DAG proper
+	NewKeyManifestCallback func(block *anlblock.Header)
+}
@@ -0,0 +1,57 @@
+package convergent
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/transform"
+	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/keystream"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/pborman/options"
+)
+
+func NewTransform(args []string, cfg *anltransform.AnlConfig) (_ anltransform.Transform, initErrs []error) {
+
+	t := &transform{
+		AnlConfig: cfg,
+		pending:   make(map[*anlblock.Header]keyInfo, 1024),
+	}
+
+	optSet := getopt.New()
+	if err := options.RegisterSet("", &t.config, optSet); err != nil {
+		initErrs = []error{fmt.Errorf("option set registration failed: %s", err)}
+		return
+	}
+
+	// on nil-args the "error" is the help text to be incorporated into
+	// the larger help display
+	if args == nil {
+		initErrs = argparser.SubHelp(
+			"Convergent encryption: every leaf is encrypted with AES-256-CTR under a key\n"+
+				"derived from the hash of its own plaintext, optionally mixed with a tenant\n"+
+				"secret, so that identical data still deduplicates. For every link node an\n"+
+				"additional encrypted raw block (the key-manifest) is emitted, holding the\n"+
+				"keys of the node's children in link order. Manifests are not linked from\n"+
+				"the DAG: the key and manifest CID needed to decrypt each DAG are only\n"+
+				"reported in the corresponding root event, so keep those alongside the CAR.\n"+
+				"Note: names/paths given to key-env/key-file may not contain '_'.",
+			optSet,
+		)
+		return
+	}
+
+	// bail early if getopt fails
+	if initErrs = argparser.Parse(args, optSet); len(initErrs) > 0 {
+		return
+	}
+
+	if t.KeyEnv != "" || t.KeyFile != "" {
+		var err error
+		if t.secret, err = keystream.LoadSecret(t.KeyEnv, t.KeyFile); err != nil {
+			initErrs = append(initErrs, err)
+		}
+	}
+
+	return t, initErrs
+}
@@ -0,0 +1,169 @@
+package convergent
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/constants"
+	"github.com/anjor/anelace/internal/transform"
+	"github.com/anjor/anelace/internal/util/encoding"
+	"github.com/anjor/anelace/internal/util/zcpstring"
+	"log"
+	"strings"
+	"sync"
+)
+
+type config struct {
+	KeyEnv  string `getopt:"--key-env=varname Mix the tenant secret held in this environment variable into every derived key. Dedup then only works between inputs processed with the same secret"`
+	KeyFile string `getopt:"--key-file=path   Mix the tenant secret read from this file into every derived key"`
+}
+
+type transform struct {
+	config
+	*anltransform.AnlConfig
+	secret []byte
+
+	mu      sync.Mutex
+	pending map[*anlblock.Header]keyInfo // blocks not yet linked by anything
+}
+
+type keyInfo struct {
+	key      [32]byte
+	manifest *anlblock.Header
+}
+
+// Manifests are standalone raw blocks: nothing in the DAG links to them, the
+// root manifest is only reachable through the CID reported in the root event.
+// Linking them from the nodes themselves would break the UnixFS file layout,
+// which expects one link per chunk of the file. A cleartext marker sets them
+// apart from leaves for anything reading a CAR without the root events.
+//
+// Manifest layout (after the marker, before encryption):
+//
+//	version byte
+//	for every child in link order:
+//		32 byte key of the child
+//		varint length + CID of the child's own manifest, 0-length for leaves
+const (
+	manifestMarker  = "\x00anelace key manifest\x00"
+	manifestVersion = 1
+)
+
+// IsKeyManifest tells whether a raw block is a key manifest
+func IsKeyManifest(data []byte) bool {
+	return strings.HasPrefix(string(data), manifestMarker)
+}
+
+const (
+	labelLeaf     = "anelace convergent leaf"
+	labelManifest = "anelace convergent manifest"
+)
+
+func (t *transform) NewLeaf(ds anlblock.DataSource) *anlblock.Header {
+
+	var buf []byte
+	if ds.Content != nil {
+		buf = ds.Content.AppendTo(make([]byte, 0, ds.Content.Size()))
+	}
+
+	key := t.deriveKey(labelLeaf, buf)
+	encrypt(key, buf)
+	ds.Content = zcpstring.WrapSlice(buf)
+
+	hdr := t.NodeEncoder.NewLeaf(ds)
+
+	t.mu.Lock()
+	t.pending[hdr] = keyInfo{key: key}
+	t.mu.Unlock()
+
+	return hdr
+}
+
+func (t *transform) NewLink(blocks []*anlblock.Header) *anlblock.Header {
+
+	hdr := t.NodeEncoder.NewLink(blocks)
+
+	// compat nul-node: nothing encrypted underneath
+	if blocks == nil {
+		return hdr
+	}
+
+	children := make([]keyInfo, len(blocks))
+	t.mu.Lock()
+	for i := range blocks {
+		var found bool
+		children[i], found = t.pending[blocks[i]]
+		if constants.PerformSanityChecks && !found {
+			log.Panicf("child #%d of a new link node carries no key: it was not formed by this transform, or is linked twice", i)
+		}
+		delete(t.pending, blocks[i])
+	}
+	t.mu.Unlock()
+
+	manifest := make([]byte, 1, 1+len(blocks)*(32+1+40))
+	manifest[0] = manifestVersion
+	for i := range children {
+		manifest = append(manifest, children[i].key[:]...)
+		if children[i].manifest == nil {
+			manifest = append(manifest, 0)
+		} else {
+			mCid := children[i].manifest.Cid()
+			manifest = encoding.AppendVarint(manifest, uint64(len(mCid)))
+			manifest = append(manifest, mCid...)
+		}
+	}
+
+	key := t.deriveKey(labelManifest, manifest)
+	encrypt(key, manifest)
+
+	mHdr := t.BlockMaker(
+		zcpstring.WrapSlice(append([]byte(manifestMarker), manifest...)),
+		anlblock.CodecRaw,
+		0,
+		0,
+	)
+	t.NewKeyManifestCallback(mHdr)
+
+	t.mu.Lock()
+	t.pending[hdr] = keyInfo{key: key, manifest: mHdr}
+	t.mu.Unlock()
+
+	return hdr
+}
+
+func (t *transform) RootKey(root *anlblock.Header) ([]byte, *anlblock.Header) {
+	t.mu.Lock()
+	ki, found := t.pending[root]
+	delete(t.pending, root)
+	t.mu.Unlock()
+
+	if !found {
+		return nil, nil
+	}
+	return ki.key[:], ki.manifest
+}
+
+// HMAC-SHA256( tenantSecret, label || sha256(plaintext) )
+// The tenant secret is empty unless one was supplied, which yields classic
+// convergent encryption
+func (t *transform) deriveKey(label string, plaintext []byte) (key [32]byte) {
+	ptHash := sha256.Sum256(plaintext)
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(label)) //nolint:errcheck
+	mac.Write(ptHash[:])     //nolint:errcheck
+	mac.Sum(key[:0])
+	return
+}
+
+// AES-256-CTR in place. A fixed IV is fine: a key is only ever derived from,
+// and thus used on, one specific plaintext
+func encrypt(key [32]byte, buf []byte) {
+	blk, err := aes.NewCipher(key[:])
+	if err != nil {
+		// can not happen: the key size is fixed
+		panic(err)
+	}
+	cipher.NewCTR(blk, make([]byte, aes.BlockSize)).XORKeyStream(buf, buf)
+}
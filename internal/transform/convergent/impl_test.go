@@ -0,0 +1,167 @@
+package convergent
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/encoder"
+	"github.com/anjor/anelace/internal/encoder/unixfsv1"
+	"github.com/anjor/anelace/internal/transform"
+	"github.com/anjor/anelace/internal/util/zcpstring"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestTransform(t *testing.T, args ...string) (anltransform.Transform, map[string]*anlblock.Header) {
+	maker, _, err := anlblock.MakerFromConfig("sha2-256", 32, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noop := func(*anlblock.Header) {}
+	emitted := make(map[string]*anlblock.Header)
+
+	enc, errs := unixfsv1.NewEncoder([]string{"unixfsv1"}, &anlencoder.AnlConfig{
+		BlockMaker:           maker,
+		HasherName:           "sha2-256",
+		HasherBits:           256,
+		NewLinkBlockCallback: noop,
+	})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	tr, errs := NewTransform(append([]string{"convergent"}, args...), &anltransform.AnlConfig{
+		NodeEncoder:            enc,
+		BlockMaker:             maker,
+		NewKeyManifestCallback: func(h *anlblock.Header) { emitted[string(h.Cid())] = h },
+	})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	return tr, emitted
+}
+
+func decrypt(key []byte, hdr *anlblock.Header) []byte {
+	buf := hdr.Content().AppendTo(nil)
+	blk, _ := aes.NewCipher(key)
+	cipher.NewCTR(blk, make([]byte, aes.BlockSize)).XORKeyStream(buf, buf)
+	return buf
+}
+
+type manifestEntry struct {
+	key         []byte
+	manifestCid []byte
+}
+
+func parseManifest(t *testing.T, key []byte, hdr *anlblock.Header) (entries []manifestEntry) {
+	m := hdr.Content().AppendTo(nil)
+	if !IsKeyManifest(m) {
+		t.Fatalf("no manifest marker in %x", m)
+	}
+	m = m[len(manifestMarker):]
+	blk, _ := aes.NewCipher(key)
+	cipher.NewCTR(blk, make([]byte, aes.BlockSize)).XORKeyStream(m, m)
+
+	if len(m) == 0 || m[0] != manifestVersion {
+		t.Fatalf("unexpected manifest version in %x", m)
+	}
+	m = m[1:]
+	for len(m) > 0 {
+		e := manifestEntry{key: m[:32]}
+		l, n := binary.Uvarint(m[32:])
+		e.manifestCid = m[32+n : 32+n+int(l)]
+		m = m[32+n+int(l):]
+		entries = append(entries, e)
+	}
+	return
+}
+
+func TestConvergentRoundtrip(t *testing.T) {
+
+	seed := time.Now().UnixNano()
+	rnd := rand.New(rand.NewSource(seed))
+
+	chunks := make([][]byte, 5)
+	for i := range chunks {
+		chunks[i] = make([]byte, 1024+rnd.Intn(64*1024))
+		rnd.Read(chunks[i]) //nolint:errcheck
+	}
+	chunks[3] = chunks[0] // something to dedup
+
+	tr, emitted := newTestTransform(t)
+
+	leaves := make([]*anlblock.Header, len(chunks))
+	for i := range chunks {
+		leaves[i] = tr.NewLeaf(anlblock.DataSource{Content: zcpstring.WrapSlice(chunks[i])})
+		if bytes.Contains(leaves[i].Content().AppendTo(nil), chunks[i][:64]) {
+			t.Fatalf("leaf #%d contains plaintext (seed %d)", i, seed)
+		}
+	}
+	if !bytes.Equal(leaves[0].Cid(), leaves[3].Cid()) {
+		t.Errorf("identical chunks resulted in different leaf CIDs (seed %d)", seed)
+	}
+
+	// two levels: inner link node over the first 3 leaves, root over it and the rest
+	inner := tr.NewLink(leaves[:3])
+	root := tr.NewLink([]*anlblock.Header{inner, leaves[3], leaves[4]})
+
+	rootKey, rootManifest := tr.RootKey(root)
+	if rootKey == nil || rootManifest == nil {
+		t.Fatal("no root key/manifest returned for a link root")
+	}
+
+	rootEntries := parseManifest(t, rootKey, rootManifest)
+	if len(rootEntries) != 3 {
+		t.Fatalf("root manifest has %d entries, expected 3", len(rootEntries))
+	}
+	if len(rootEntries[1].manifestCid) != 0 || len(rootEntries[2].manifestCid) != 0 {
+		t.Error("leaf entries carry a manifest CID")
+	}
+
+	innerManifest := emitted[string(rootEntries[0].manifestCid)]
+	if innerManifest == nil {
+		t.Fatal("manifest referenced by the root manifest was never emitted")
+	}
+	innerEntries := parseManifest(t, rootEntries[0].key, innerManifest)
+	if len(innerEntries) != 3 {
+		t.Fatalf("inner manifest has %d entries, expected 3", len(innerEntries))
+	}
+
+	leafKeys := [][]byte{innerEntries[0].key, innerEntries[1].key, innerEntries[2].key, rootEntries[1].key, rootEntries[2].key}
+	for i := range leaves {
+		if pt := decrypt(leafKeys[i], leaves[i]); !bytes.Equal(pt, chunks[i]) {
+			t.Errorf("leaf #%d does not decrypt to the original chunk (seed %d)", i, seed)
+		}
+	}
+
+	if k, _ := tr.RootKey(inner); k != nil {
+		t.Error("keys of an already linked node still pending")
+	}
+	for i := range leaves {
+		if IsKeyManifest(leaves[i].Content().AppendTo(nil)) {
+			t.Errorf("leaf #%d taken for a key manifest (seed %d)", i, seed)
+		}
+	}
+
+	// linking a node a second time would record an all-zero key for it
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("linking an already linked node did not panic")
+			}
+		}()
+		tr.NewLink([]*anlblock.Header{inner})
+	}()
+
+	// a tenant secret yields entirely different blocks
+	os.Setenv("ANELACETESTTENANTSECRET", "tenant-secret-0123456789") //nolint:errcheck
+	defer os.Unsetenv("ANELACETESTTENANTSECRET")
+	trTenant, _ := newTestTransform(t, "--key-env=ANELACETESTTENANTSECRET")
+	if bytes.Equal(trTenant.NewLeaf(anlblock.DataSource{Content: zcpstring.WrapSlice(chunks[0])}).Cid(), leaves[0].Cid()) {
+		t.Errorf("tenant secret did not affect the leaf CID (seed %d)", seed)
+	}
+}
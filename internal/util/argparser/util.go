@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/pborman/getopt/v2"
 )
@@ -50,16 +51,49 @@ func SubHelp(description string, optSet *getopt.Set) (sh []error) {
 var maxPlaceholder = regexp.MustCompile(`\bMaxPayload\b`)
 
 func Parse(args []string, optSet *getopt.Set) (argErrs []error) {
+	_, argErrs = parse(args, optSet, false)
+	return
+}
+
+// ParseWithParameters is Parse() for option sets taking free-form parameters
+// after the options, which are returned instead of being treated as an error
+func ParseWithParameters(args []string, optSet *getopt.Set) (params []string, argErrs []error) {
+	return parse(args, optSet, true)
+}
+
+func parse(args []string, optSet *getopt.Set, allowParams bool) (params []string, argErrs []error) {
 
 	if err := optSet.Getopt(args, nil); err != nil {
 		argErrs = append(argErrs, err)
 	}
 
-	unexpectedArgs := optSet.Args()
-	if len(unexpectedArgs) != 0 {
+	remaining := optSet.Args()
+
+	// getopt stops at the first parameter: options may follow parameters too,
+	// unless an explicit "--" ended the options
+	for allowParams && len(argErrs) == 0 && len(remaining) > 0 && args[len(args)-len(remaining)-1] != "--" {
+
+		i := 0
+		for i < len(remaining) && (remaining[i] == "-" || !strings.HasPrefix(remaining[i], "-")) {
+			i++
+		}
+		if i == len(remaining) {
+			break
+		}
+
+		params = append(params, remaining[:i]...)
+		args = append([]string{args[0]}, remaining[i:]...)
+		if err := optSet.Getopt(args, nil); err != nil {
+			argErrs = append(argErrs, err)
+		}
+		remaining = optSet.Args()
+	}
+	params = append(params, remaining...)
+
+	if len(params) != 0 && !allowParams {
 		argErrs = append(argErrs, fmt.Errorf(
 			"unexpected free-form parameter(s): %s...",
-			unexpectedArgs[0],
+			params[0],
 		))
 	}
 
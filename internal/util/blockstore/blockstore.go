@@ -0,0 +1,65 @@
+package blockstore
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/util/multibase"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var b32, _ = multibase.Lookup("base32")
+
+// Dir is a flat directory of blocks, one file per block, named by the
+// base32 multibase rendition of its binary CID
+type Dir struct {
+	path string
+}
+
+func Open(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create blockstore directory: %s", err)
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) filename(cid []byte) string {
+	return filepath.Join(d.path, b32.Encode(cid))
+}
+
+func (d *Dir) Has(cid []byte) bool {
+	_, err := os.Stat(d.filename(cid))
+	return err == nil
+}
+
+// Put stores a block, unless one with the same CID is already present. The
+// write is atomic: concurrent readers never observe a partial block.
+func (d *Dir) Put(cid, data []byte) error {
+
+	fn := d.filename(cid)
+	if _, err := os.Stat(fn); err == nil {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(d.path, ".put-")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close() //nolint:errcheck
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fn)
+	}
+	if err != nil {
+		os.Remove(tmp.Name()) //nolint:errcheck
+	}
+	return err
+}
+
+// Get returns the content of a block, or an error satisfying os.IsNotExist()
+func (d *Dir) Get(cid []byte) ([]byte, error) {
+	return ioutil.ReadFile(d.filename(cid))
+}
@@ -0,0 +1,181 @@
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/anjor/anelace/internal/constants"
+	"io"
+)
+
+// Reader iterates over the blocks of a CARv1 stream, as emitted by
+// car-v1-stream. The header is skipped without interpretation.
+type Reader struct {
+	r            *bufio.Reader
+	pos          int64
+	headerParsed bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 256*1024)}
+}
+
+// Pos is the amount of stream bytes consumed so far. Right after Next() the
+// returned data starts at Pos()-len(data)
+func (cr *Reader) Pos() int64 { return cr.pos }
+
+type posCountingReader Reader
+
+func (cr *posCountingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.pos++
+	}
+	return b, err
+}
+
+// Next returns the CID and content of the next block, or io.EOF once the
+// stream is cleanly exhausted. The returned slices are not reused.
+func (cr *Reader) Next() (cid, data []byte, err error) {
+
+	if !cr.headerParsed {
+		hdrLen, err := binary.ReadUvarint((*posCountingReader)(cr))
+		if err == io.EOF {
+			return nil, nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, nil, err
+		}
+		n, err := cr.r.Discard(int(hdrLen))
+		cr.pos += int64(n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated car header: %s", err)
+		}
+		cr.headerParsed = true
+	}
+
+	secLen, err := binary.ReadUvarint((*posCountingReader)(cr))
+	if err != nil {
+		// a clean EOF is only possible at a section boundary
+		return nil, nil, err
+	}
+	if secLen > constants.MaxBlockWireSize+128 {
+		return nil, nil, fmt.Errorf("car section of %d bytes exceeds maximum block size", secLen)
+	}
+
+	sec := make([]byte, secLen)
+	n, err := io.ReadFull(cr.r, sec)
+	cr.pos += int64(n)
+	if err != nil {
+		return nil, nil, fmt.Errorf("truncated car section: %s", err)
+	}
+
+	cidLen, err := CidLength(sec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sec[:cidLen:cidLen], sec[cidLen:], nil
+}
+
+// CidLength returns the length of the binary CID at the start of b
+func CidLength(b []byte) (int, error) {
+
+	// CIDv0 is a bare sha2-256 multihash
+	if len(b) >= 34 && b[0] == 0x12 && b[1] == 0x20 {
+		return 34, nil
+	}
+
+	var pos int
+	// version, codec, multihash type, digest length
+	for i := 0; i < 4; i++ {
+		v, n := binary.Uvarint(b[pos:])
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid CID varint at offset %d", pos)
+		}
+		if i == 0 && v != 1 {
+			return 0, fmt.Errorf("unsupported CID version %d", v)
+		}
+		pos += n
+		if i == 3 {
+			// compare before adding: a huge length would wrap around int
+			if v > uint64(len(b)-pos) {
+				return 0, fmt.Errorf("truncated CID")
+			}
+			pos += int(v)
+		}
+	}
+
+	return pos, nil
+}
+
+// ParseCid splits a binary CID into its codec and multihash components
+func ParseCid(cid []byte) (codec, mhType uint64, digest []byte, err error) {
+
+	l, err := CidLength(cid)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if l != len(cid) {
+		return 0, 0, nil, fmt.Errorf("%d trailing bytes after CID", len(cid)-l)
+	}
+
+	if cid[0] == 0x12 {
+		return 0x70, 0x12, cid[2:], nil
+	}
+
+	var n int
+	pos := 1
+	codec, n = binary.Uvarint(cid[pos:])
+	pos += n
+	mhType, n = binary.Uvarint(cid[pos:])
+	pos += n
+	_, n = binary.Uvarint(cid[pos:])
+	pos += n
+
+	return codec, mhType, cid[pos:], nil
+}
+
+// CidV1 returns the CIDv1 equivalent of a CIDv0, anything else is returned
+// as-is. car-v1-stream always emits blocks under their CIDv1, even when the
+// links pointing to them are CIDv0.
+func CidV1(cid []byte) []byte {
+	if len(cid) == 34 && cid[0] == 0x12 && cid[1] == 0x20 {
+		return append([]byte{0x01, 0x70}, cid...)
+	}
+	return cid
+}
+
+// WriteHeader writes a CARv1 header listing a single root
+func WriteHeader(w io.Writer, root []byte) error {
+
+	cborCid := append([]byte{0x00}, root...)
+
+	hdr := make([]byte, 0, 32+len(cborCid))
+	// map with 2 keys, text-key "roots", 1 element array, tag 42
+	hdr = append(hdr, "\xA2\x65roots\x81\xD8\x2A"...)
+	// bytes of the binary CID, with the multibase-identity prefix
+	switch {
+	case len(cborCid) < 24:
+		hdr = append(hdr, 0x40|byte(len(cborCid)))
+	case len(cborCid) < 256:
+		hdr = append(hdr, 0x58, byte(len(cborCid)))
+	default:
+		hdr = append(hdr, 0x59, byte(len(cborCid)>>8), byte(len(cborCid)))
+	}
+	hdr = append(hdr, cborCid...)
+	// text-key "version", value 1
+	hdr = append(hdr, "\x67version\x01"...)
+
+	return WriteSection(w, nil, hdr)
+}
+
+// WriteSection writes a varint-length-prefixed block
+func WriteSection(w io.Writer, cid, data []byte) error {
+	sizeVI := make([]byte, binary.MaxVarintLen64)
+	sizeVI = sizeVI[:binary.PutUvarint(sizeVI, uint64(len(cid)+len(data)))]
+	for _, b := range [][]byte{sizeVI, cid, data} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package car
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+var sha256Digest = []byte(strings.Repeat("\xaa", 32))
+
+func TestCidLength(t *testing.T) {
+
+	v0 := append([]byte{0x12, 0x20}, sha256Digest...)
+	v1 := append([]byte{0x01, 0x55, 0x12, 0x20}, sha256Digest...)
+
+	for _, tc := range []struct {
+		name   string
+		b      []byte
+		length int
+		err    string
+	}{
+		{"CIDv0", v0, 34, ""},
+		{"CIDv0 with trailing payload", append(v0, "payload"...), 34, ""},
+		{"CIDv1", v1, 36, ""},
+		{"CIDv1 with trailing payload", append(v1, "payload"...), 36, ""},
+		{"identity CIDv1", []byte{0x01, 0x55, 0x00, 0x03, 'a', 'b', 'c'}, 7, ""},
+		{"empty", nil, 0, "invalid CID varint"},
+		{"CIDv2", []byte{0x02, 0x55, 0x12, 0x20}, 0, "unsupported CID version 2"},
+		{"missing digest length", []byte{0x01, 0x55, 0x12}, 0, "invalid CID varint"},
+		{"truncated digest", v1[:35], 0, "truncated CID"},
+		{"digest length wrapping int", []byte{0x01, 0x55, 0x12, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0x00}, 0, "truncated CID"},
+		{"digest length past int", []byte{0x01, 0x55, 0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x00}, 0, "truncated CID"},
+	} {
+		l, err := CidLength(tc.b)
+		if tc.err == "" && (err != nil || l != tc.length) {
+			t.Errorf("%s: got length %d and error %v, expected %d", tc.name, l, err, tc.length)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got length %d and error %v, expected error '%s'", tc.name, l, err, tc.err)
+		}
+	}
+}
+
+func TestParseCid(t *testing.T) {
+
+	for _, tc := range []struct {
+		name          string
+		cid           []byte
+		codec, mhType uint64
+		digest        []byte
+		err           bool
+	}{
+		{"CIDv0", append([]byte{0x12, 0x20}, sha256Digest...), 0x70, 0x12, sha256Digest, false},
+		{"CIDv1", append([]byte{0x01, 0x55, 0x12, 0x20}, sha256Digest...), 0x55, 0x12, sha256Digest, false},
+		{"identity CIDv1", []byte{0x01, 0x70, 0x00, 0x01, 'x'}, 0x70, 0x00, []byte("x"), false},
+		{"trailing bytes", append([]byte{0x01, 0x55, 0x12, 0x20, 0x00}, sha256Digest...), 0, 0, nil, true},
+		{"truncated", []byte{0x01, 0x55, 0x12, 0x20}, 0, 0, nil, true},
+	} {
+		codec, mhType, digest, err := ParseCid(tc.cid)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: unexpected success", tc.name)
+			}
+		} else if err != nil || codec != tc.codec || mhType != tc.mhType || !bytes.Equal(digest, tc.digest) {
+			t.Errorf("%s: got 0x%x 0x%x %x %v", tc.name, codec, mhType, digest, err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+
+	root := append([]byte{0x01, 0x70, 0x12, 0x20}, sha256Digest...)
+	leaf := append([]byte{0x12, 0x20}, sha256Digest...)
+
+	var stream bytes.Buffer
+	if err := WriteHeader(&stream, root); err != nil {
+		t.Fatal(err)
+	}
+	hdrLen := stream.Len()
+	for _, sec := range [][2][]byte{{root, []byte("node")}, {CidV1(leaf), []byte("leaf")}, {leaf, nil}} {
+		if err := WriteSection(&stream, sec[0], sec[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cr := NewReader(bytes.NewReader(stream.Bytes()))
+	for i, expected := range [][2][]byte{{root, []byte("node")}, {CidV1(leaf), []byte("leaf")}, {leaf, nil}} {
+		cid, data, err := cr.Next()
+		if err != nil {
+			t.Fatalf("section %d: %s", i, err)
+		}
+		if !bytes.Equal(cid, expected[0]) || !bytes.Equal(data, expected[1]) {
+			t.Errorf("section %d: got %x %q", i, cid, data)
+		}
+		if i == 0 && cr.Pos() != int64(hdrLen+1+len(root)+4) {
+			t.Errorf("unexpected position %d after the first section", cr.Pos())
+		}
+	}
+	if _, _, err := cr.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the stream, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		stream []byte
+	}{
+		{"empty", nil},
+		{"truncated header", []byte{0x10, 0xa2}},
+		{"truncated section", append(stream.Bytes()[:hdrLen:hdrLen], 0x30, 0x01, 0x55)},
+		{"oversized section", append(stream.Bytes()[:hdrLen:hdrLen], 0xff, 0xff, 0xff, 0xff, 0x0f)},
+		{"wrapping digest length", []byte{0x01, 0xa0, 0x0e, 0x01, 0x55, 0x12, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0x00}},
+	} {
+		cr := NewReader(bytes.NewReader(tc.stream))
+		var err error
+		for err == nil {
+			_, _, err = cr.Next()
+		}
+		if err == io.EOF {
+			t.Errorf("%s: malformed stream ended cleanly", tc.name)
+		}
+	}
+}
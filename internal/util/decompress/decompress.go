@@ -0,0 +1,113 @@
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"github.com/anjor/anelace/internal/util/text"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type format struct {
+	magic  []byte
+	reader func(io.Reader) (io.Reader, func(), error)
+}
+
+var noClose = func() {}
+
+// "none" and "auto" are handled separately
+var formats = map[string]format{
+	"zstd": {
+		magic: []byte{0x28, 0xB5, 0x2F, 0xFD},
+		reader: func(r io.Reader) (io.Reader, func(), error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			return d, d.Close, nil
+		},
+	},
+	"xz": {
+		magic: []byte{0xFD, '7', 'z', 'X', 'Z', 0x00},
+		reader: func(r io.Reader) (io.Reader, func(), error) {
+			d, err := xz.NewReader(r)
+			return d, noClose, err
+		},
+	},
+	"gzip": {
+		magic: []byte{0x1F, 0x8B},
+		reader: func(r io.Reader) (io.Reader, func(), error) {
+			d, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, nil, err
+			}
+			return d, func() { d.Close() }, nil //nolint:errcheck
+		},
+	},
+	"bzip2": {
+		magic: []byte{'B', 'Z', 'h'},
+		reader: func(r io.Reader) (io.Reader, func(), error) {
+			return bzip2.NewReader(r), noClose, nil
+		},
+	},
+}
+
+// AvailableFormats lists everything accepted by NewReader()
+func AvailableFormats() string {
+	return "'none', 'auto', " + text.AvailableMapKeys(formats)
+}
+
+func IsValidFormat(f string) bool {
+	_, known := formats[f]
+	return known || f == "none" || f == "auto"
+}
+
+// NewReader returns a reader over the decompressed content of r. With format
+// "auto" the compression is detected from the leading magic bytes, and an
+// unrecognized input is passed through as-is. The returned closer must be
+// called once the reader is no longer needed.
+func NewReader(r io.Reader, f string) (io.Reader, func(), error) {
+
+	if f == "none" {
+		return r, noClose, nil
+	}
+
+	if f == "auto" {
+		// a large enough buffer lets the large reads of the ring buffer go
+		// straight through once the peeked-at portion is consumed
+		br := bufio.NewReaderSize(r, 64*1024)
+		r = br
+
+		f = "none"
+		for name, spec := range formats {
+			head, _ := br.Peek(len(spec.magic)) // short reads/EOF simply do not match
+			if bytes.Equal(head, spec.magic) {
+				f = name
+				break
+			}
+		}
+		if f == "none" {
+			return r, noClose, nil
+		}
+	}
+
+	spec, known := formats[f]
+	if !known {
+		return nil, nil, fmt.Errorf(
+			"unknown decompression format '%s', available formats are: %s",
+			f,
+			AvailableFormats(),
+		)
+	}
+
+	d, closer, err := spec.reader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("initialization of %s decompressor failed: %s", f, err)
+	}
+	return d, closer, nil
+}
@@ -0,0 +1,87 @@
+package decompress
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// there is no bzip2 compressor in the standard library: this is 40,000 repetitions
+// of "anelace bzip2 test vector\n" compressed with `bzip2 -9`
+const bzip2Vector = "QlpoOTFBWSZTWa05O7sB2T9ZgAAQQAAQADol3RAwATDUFDTTAAJqqI2ppkeUBSqhkH6hHqfIihVehFCq8iKFVmIoVX0RQquxFCqxEUKrPIihVexFCq5iKFV2IoVWoihVZEFQZUoqDVSioN6lAqsCKFV4iKFVoRRBpSioNqlFQYpRUGVKKgxSioOylFQaqUVB/MUFZJlNZwrOd4gDc4dmAABBAABAAOiXdEDAA+AKGmmAAoaaYAApVQ02o2pjU/JBJiIJNogk6SCTmIJMxBJvEEmIgk+RBJhIJOIgk7SCTmIJPsQSaiCT3EEm8QSekgk6iCTtIJNogk41EEmYgkykEnhIJNRBJ/F3JFOFCQmMHqlQ"
+
+func TestDecompressFormats(t *testing.T) {
+
+	seed := time.Now().UnixNano()
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(seed)).Read(data[:1024*1024]) //nolint:errcheck
+	copy(data[2*1024*1024:], data[:1024*1024])
+
+	compressed := map[string][]byte{}
+
+	var buf bytes.Buffer
+	zw, _ := zstd.NewWriter(&buf)
+	zw.Write(data) //nolint:errcheck
+	zw.Close()     //nolint:errcheck
+	compressed["zstd"] = append([]byte{}, buf.Bytes()...)
+
+	buf.Reset()
+	gw := gzip.NewWriter(&buf)
+	gw.Write(data) //nolint:errcheck
+	gw.Close()     //nolint:errcheck
+	compressed["gzip"] = append([]byte{}, buf.Bytes()...)
+
+	buf.Reset()
+	xw, _ := xz.NewWriter(&buf)
+	xw.Write(data) //nolint:errcheck
+	xw.Close()     //nolint:errcheck
+	compressed["xz"] = append([]byte{}, buf.Bytes()...)
+
+	check := func(format, inFormat string, in, expected []byte) {
+		r, closer, err := NewReader(bytes.NewReader(in), format)
+		if err != nil {
+			t.Fatalf("%s of %s: %s", format, inFormat, err)
+		}
+		defer closer()
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s of %s: %s", format, inFormat, err)
+		}
+		if !bytes.Equal(out, expected) {
+			t.Errorf("%s of %s: decompressed content mismatch (seed %d)", format, inFormat, seed)
+		}
+	}
+
+	for f, c := range compressed {
+		check(f, f, c, data)
+		check("auto", f, c, data)
+	}
+
+	bz, _ := base64.StdEncoding.DecodeString(bzip2Vector)
+	bzExpected := bytes.Repeat([]byte("anelace bzip2 test vector\n"), 40000)
+	check("bzip2", "bzip2", bz, bzExpected)
+	check("auto", "bzip2", bz, bzExpected)
+
+	// passthrough of anything unrecognized, including inputs shorter than any magic
+	check("auto", "raw", data, data)
+	check("auto", "raw", []byte{0x1F}, []byte{0x1F})
+	check("auto", "raw", nil, []byte{})
+	check("none", "raw", compressed["zstd"], compressed["zstd"])
+
+	// mismatched explicit format must fail, not pass through
+	if r, closer, err := NewReader(bytes.NewReader(data), "xz"); err == nil {
+		_, err = io.Copy(ioutil.Discard, r)
+		closer()
+		if err == nil {
+			t.Error("xz decompression of random data unexpectedly succeeded")
+		}
+	}
+}
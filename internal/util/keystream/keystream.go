@@ -0,0 +1,86 @@
+package keystream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+)
+
+// Reader is an endless deterministic byte stream, formed by concatenating
+// HMAC-SHA256( secret, label || uint64BE(counter) ) for counter = 0, 1, ...
+// Different labels yield unrelated streams from the same secret, so that one
+// seed/key can safely parametrize several independent things.
+type Reader struct {
+	mac   hash.Hash
+	label []byte
+	ctr   uint64
+	buf   []byte
+}
+
+func New(secret []byte, label string) *Reader {
+	return &Reader{
+		mac:   hmac.New(sha256.New, secret),
+		label: []byte(label),
+	}
+}
+
+// Read always fills p completely and never returns an error
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			var ctr [8]byte
+			binary.BigEndian.PutUint64(ctr[:], r.ctr)
+			r.ctr++
+
+			r.mac.Reset()
+			r.mac.Write(r.label) //nolint:errcheck
+			r.mac.Write(ctr[:])  //nolint:errcheck
+			r.buf = r.mac.Sum(nil)
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Anything shorter is likely a passphrase typo rather than a key
+const MinSecretKeyLen = 16
+
+// LoadSecret retrieves a secret key from either the named environment
+// variable or the named file (a single trailing newline is stripped). Only
+// these names are ever part of the plugin sub-args, thus the key itself never
+// makes it into the stats output.
+func LoadSecret(envName, fileName string) (key []byte, err error) {
+
+	if envName != "" && fileName != "" {
+		return nil, fmt.Errorf("options 'key-env' and 'key-file' are mutually exclusive")
+	} else if envName != "" {
+		v, isSet := os.LookupEnv(envName)
+		if !isSet {
+			return nil, fmt.Errorf("environment variable '%s' specified via 'key-env' is not set", envName)
+		}
+		key = []byte(v)
+	} else if fileName != "" {
+		if key, err = ioutil.ReadFile(fileName); err != nil {
+			return nil, fmt.Errorf("unable to read key-file: %s", err)
+		}
+		if bytes.HasSuffix(key, []byte("\n")) {
+			key = bytes.TrimSuffix(key[:len(key)-1], []byte("\r"))
+		}
+	} else {
+		return nil, fmt.Errorf("one of 'key-env' or 'key-file' must be specified")
+	}
+
+	if len(key) < MinSecretKeyLen {
+		return nil, fmt.Errorf("secret key must be at least %d bytes long, got %d", MinSecretKeyLen, len(key))
+	}
+
+	return key, nil
+}
@@ -0,0 +1,206 @@
+package multibase
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/multiformats/go-base36"
+)
+
+// https://github.com/multiformats/multibase/blob/master/multibase.csv
+type Encoding struct {
+	Name     string
+	Prefix   byte
+	alphabet string
+	encode   func([]byte) string
+	decode   func(string) ([]byte, error)
+}
+
+// Encode returns the multibase string, including the prefix character
+func (e *Encoding) Encode(b []byte) string {
+	return string(e.Prefix) + e.encode(b)
+}
+
+// Placeholder keeps the prefix of an encoded string and as many characters as
+// needed to carry its first keepBits bits, then overwrites the rest with
+// marker followed by as many 'z' as needed. Characters outside of the
+// alphabet of the encoding are substituted by its last one, so that the
+// result remains decodable, at its original length.
+func (e *Encoding) Placeholder(encoded string, keepBits int, marker string) string {
+	filler := e.alphabet[len(e.alphabet)-1]
+	if strings.IndexByte(e.alphabet, 'z') >= 0 {
+		filler = 'z'
+	}
+
+	offset := 1 + int(math.Ceil(float64(keepBits)/math.Log2(float64(len(e.alphabet)))))
+
+	b := []byte(encoded)
+	for i := offset; i < len(b); i++ {
+		c := filler
+		if i-offset < len(marker) && strings.IndexByte(e.alphabet, marker[i-offset]) >= 0 {
+			c = marker[i-offset]
+		}
+		b[i] = c
+	}
+	return string(b)
+}
+
+const (
+	b16Alphabet = "0123456789abcdef"
+	b32Alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	b36Alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+	b64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+var b32Encoder = base32.NewEncoding(b32Alphabet).WithPadding(base32.NoPadding)
+
+var encodings = []*Encoding{
+	{Name: "base2", Prefix: '0', alphabet: "01", encode: encodeBase2, decode: decodeBase2},
+	{Name: "base16", Prefix: 'f', alphabet: b16Alphabet, encode: hex.EncodeToString, decode: hex.DecodeString},
+	{Name: "base32", Prefix: 'b', alphabet: b32Alphabet, encode: b32Encoder.EncodeToString, decode: b32Encoder.DecodeString},
+	{Name: "base36", Prefix: 'k', alphabet: b36Alphabet, encode: base36.EncodeToStringLc, decode: base36.DecodeString},
+	{Name: "base58btc", Prefix: 'z', alphabet: b58Alphabet, encode: EncodeBase58btc, decode: DecodeBase58btc},
+	{Name: "base64url", Prefix: 'u', alphabet: b64Alphabet, encode: base64.RawURLEncoding.EncodeToString, decode: base64.RawURLEncoding.DecodeString},
+}
+
+// Decode parses any of the supported multibase strings, including the prefix
+// character. A bare CIDv0 ("Qm...") is accepted as well.
+func Decode(s string) ([]byte, error) {
+
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		return DecodeBase58btc(s)
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("empty multibase string")
+	}
+
+	e, known := Lookup(s[:1])
+	if !known {
+		return nil, fmt.Errorf("unsupported multibase prefix '%c'", s[0])
+	}
+	b, err := e.decode(s[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s string: %s", e.Name, err)
+	}
+	return b, nil
+}
+
+// Lookup finds an encoding either by name or by its prefix character
+func Lookup(nameOrPrefix string) (*Encoding, bool) {
+	for _, e := range encodings {
+		if nameOrPrefix == e.Name || (len(nameOrPrefix) == 1 && nameOrPrefix[0] == e.Prefix) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func AvailableNames() string {
+	names := make([]string, len(encodings))
+	for i, e := range encodings {
+		names[i] = "'" + e.Name + "'"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func encodeBase2(b []byte) string {
+	var sb strings.Builder
+	sb.Grow(8 * len(b))
+	for _, c := range b {
+		for bit := 7; bit >= 0; bit-- {
+			sb.WriteByte('0' + (c>>uint(bit))&1)
+		}
+	}
+	return sb.String()
+}
+
+func decodeBase2(s string) ([]byte, error) {
+	if len(s)%8 != 0 {
+		return nil, fmt.Errorf("length %d is not a multiple of 8", len(s))
+	}
+	out := make([]byte, len(s)/8)
+	for i := range s {
+		switch s[i] {
+		case '1':
+			out[i/8] |= 1 << uint(7-i%8)
+		case '0':
+		default:
+			return nil, fmt.Errorf("invalid character '%c' at offset %d", s[i], i)
+		}
+	}
+	return out, nil
+}
+
+const b58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// EncodeBase58btc is the bare (non-prefixed) encoding, as used by CIDv0
+func EncodeBase58btc(b []byte) string {
+
+	var zeroes int
+	for zeroes < len(b) && b[zeroes] == 0 {
+		zeroes++
+	}
+
+	// log(256) / log(58) ~= 1.37
+	digits := make([]byte, 0, len(b)*138/100+1)
+	for _, c := range b[zeroes:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	out := make([]byte, zeroes+len(digits))
+	for i := 0; i < zeroes; i++ {
+		out[i] = b58Alphabet[0]
+	}
+	for i := range digits {
+		out[zeroes+i] = b58Alphabet[digits[len(digits)-1-i]]
+	}
+	return string(out)
+}
+
+// DecodeBase58btc is the inverse of EncodeBase58btc
+func DecodeBase58btc(s string) ([]byte, error) {
+
+	var zeroes int
+	for zeroes < len(s) && s[zeroes] == b58Alphabet[0] {
+		zeroes++
+	}
+
+	// log(58) / log(256) ~= 0.733
+	b256 := make([]byte, 0, len(s)*733/1000+1)
+	for i := zeroes; i < len(s); i++ {
+		carry := strings.IndexByte(b58Alphabet, s[i])
+		if carry < 0 {
+			return nil, fmt.Errorf("invalid character '%c' at offset %d", s[i], i)
+		}
+		for j := range b256 {
+			carry += int(b256[j]) * 58
+			b256[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			b256 = append(b256, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	out := make([]byte, zeroes+len(b256))
+	for i := range b256 {
+		out[zeroes+i] = b256[len(b256)-1-i]
+	}
+	return out, nil
+}
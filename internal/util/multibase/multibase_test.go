@@ -0,0 +1,57 @@
+package multibase
+
+import "testing"
+
+func TestEncodings(t *testing.T) {
+
+	if s := EncodeBase58btc([]byte("hello world")); s != "StV1DL6CwTryKyV" {
+		t.Errorf("unexpected base58btc encoding %s", s)
+	}
+	if s := EncodeBase58btc([]byte{0, 0, 1}); s != "112" {
+		t.Errorf("leading zeroes not preserved: %s", s)
+	}
+
+	for nameOrPrefix, expected := range map[string]string{
+		"base2":     "001000001",
+		"f":         "f41",
+		"base32":    "bie",
+		"u":         "uQQ",
+		"base58btc": "z28",
+	} {
+		e, found := Lookup(nameOrPrefix)
+		if !found {
+			t.Fatalf("encoding %s not found", nameOrPrefix)
+		}
+		if s := e.Encode([]byte("A")); s != expected {
+			t.Errorf("%s encoding of 'A' is %s, expected %s", nameOrPrefix, s, expected)
+		}
+	}
+
+	cid := []byte("\x01\x55\x12\x20\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f")
+	for _, e := range encodings {
+		if b, err := Decode(e.Encode(cid)); err != nil || string(b) != string(cid) {
+			t.Errorf("%s roundtrip failed: %x %v", e.Name, b, err)
+		}
+	}
+	if b, err := Decode(EncodeBase58btc(cid[2:])); err != nil || string(b) != string(cid[2:]) {
+		t.Errorf("bare CIDv0 roundtrip failed: %x %v", b, err)
+	}
+
+	// a dummy-hashed CID rendering must remain a CID of the same length
+	for _, e := range encodings {
+		p := e.Placeholder(e.Encode(cid), 45, "zzzznohash")
+		if b, err := Decode(p); err != nil || len(b) != len(cid) || string(b[:4]) != string(cid[:4]) {
+			t.Errorf("%s placeholder %s does not decode into a CID: %x %v", e.Name, p, b, err)
+		}
+	}
+	for _, n := range []string{"base32", "base36"} {
+		e, _ := Lookup(n)
+		if p := e.Placeholder("k23456789abcdefghijkl", 45, "zzzznohash"); p != "k23456789azzzznohashz" {
+			t.Errorf("%s placeholder %s is not the established 'zzzznohash' one", n, p)
+		}
+	}
+
+	if _, found := Lookup("x"); found {
+		t.Error("unknown prefix unexpectedly found")
+	}
+}
@@ -0,0 +1,437 @@
+package unixfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/anjor/anelace/internal/encoder/unixfsv1"
+	"github.com/anjor/anelace/internal/util/car"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// https://github.com/ipfs/go-unixfs/blob/master/pb/unixfs.proto
+const (
+	TypeRaw       = 0
+	TypeDirectory = 1
+	TypeFile      = 2
+	TypeMetadata  = 3
+	TypeSymlink   = 4
+	TypeHAMTShard = 5
+)
+
+const (
+	codecRaw    = 0x55
+	codecPB     = 0x70
+	mhIdentity  = 0x00
+	wireVarint  = 0
+	wireLenDlmt = 2
+)
+
+// BlockGetter is anything able to retrieve a block by its binary CIDv1. A
+// missing block must be signalled by an error satisfying os.IsNotExist()
+type BlockGetter interface {
+	Get(cid []byte) ([]byte, error)
+}
+
+// MissingBlockError is returned whenever a DAG refers to an unavailable block
+type MissingBlockError struct {
+	Cid []byte
+}
+
+func (e *MissingBlockError) Error() string {
+	return fmt.Sprintf("block %x not available", e.Cid)
+}
+
+type Link struct {
+	Cid   []byte
+	Name  string
+	Tsize uint64
+}
+
+// Node is the decoded form of either a raw block or a dag-pb UnixFSv1 node.
+// Raw blocks are presented as a Data-only TypeRaw node.
+type Node struct {
+	Type       int
+	Data       []byte
+	FileSize   uint64
+	BlockSizes []uint64
+	Links      []Link
+}
+
+// Size is the amount of file payload covered by the node
+func (n *Node) Size() uint64 {
+	if n.Type == TypeRaw || (n.FileSize == 0 && len(n.Links) == 0) {
+		return uint64(len(n.Data))
+	}
+	if n.FileSize > 0 {
+		return n.FileSize
+	}
+	s := uint64(len(n.Data))
+	for _, bs := range n.BlockSizes {
+		s += bs
+	}
+	return s
+}
+
+// Options control the interpretation of blocks
+type Options struct {
+	// ZstdLeaves decompresses raw leaves beginning with unixfsv1.ZstdLeafMarker,
+	// as written by --experimental-zstd-leaves. Nothing prevents a regular raw
+	// leaf from beginning with the same bytes, hence this is opt-in.
+	ZstdLeaves bool
+}
+
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// GetBlock retrieves a block, resolving identity CIDs without consulting bg
+func GetBlock(bg BlockGetter, cid []byte) (data []byte, isInlined bool, err error) {
+
+	_, mhType, digest, err := car.ParseCid(cid)
+	if err != nil {
+		return nil, false, err
+	}
+	if mhType == mhIdentity {
+		return digest, true, nil
+	}
+
+	data, err = bg.Get(car.CidV1(cid))
+	if os.IsNotExist(err) {
+		return nil, false, &MissingBlockError{Cid: cid}
+	}
+	return data, false, err
+}
+
+// Load retrieves and decodes a block
+func Load(bg BlockGetter, cid []byte, opts Options) (*Node, error) {
+	data, _, err := GetBlock(bg, cid)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(cid, data, opts)
+}
+
+// Decode interprets a block according to the codec of its CID
+func Decode(cid, data []byte, opts Options) (*Node, error) {
+
+	codec, _, _, err := car.ParseCid(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch codec {
+
+	case codecRaw:
+		if opts.ZstdLeaves && bytes.HasPrefix(data, unixfsv1.ZstdLeafMarker) {
+			if data, err = zstdDecoder.DecodeAll(data[len(unixfsv1.ZstdLeafMarker):], nil); err != nil {
+				return nil, fmt.Errorf("invalid zstd leaf %x: %s", cid, err)
+			}
+		}
+		return &Node{Type: TypeRaw, Data: data}, nil
+
+	case codecPB:
+		n, err := decodePB(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dag-pb block %x: %s", cid, err)
+		}
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported codec 0x%x of block %x", codec, cid)
+	}
+}
+
+func decodePB(b []byte) (*Node, error) {
+
+	n := &Node{Type: -1}
+
+	err := pbFields(b, func(field int, _ uint64, ld []byte) error {
+		switch field {
+
+		case 1:
+			return pbFields(ld, func(field int, v uint64, ld []byte) error {
+				switch field {
+				case 1:
+					n.Type = int(v)
+				case 2:
+					n.Data = ld
+				case 3:
+					n.FileSize = v
+				case 4:
+					if ld == nil {
+						n.BlockSizes = append(n.BlockSizes, v)
+						break
+					}
+					// packed form
+					for len(ld) > 0 {
+						s, l := binary.Uvarint(ld)
+						if l <= 0 {
+							return fmt.Errorf("invalid packed blocksizes")
+						}
+						n.BlockSizes = append(n.BlockSizes, s)
+						ld = ld[l:]
+					}
+				}
+				return nil
+			})
+
+		case 2:
+			var l Link
+			if err := pbFields(ld, func(field int, v uint64, ld []byte) error {
+				switch field {
+				case 1:
+					l.Cid = ld
+				case 2:
+					l.Name = string(ld)
+				case 3:
+					l.Tsize = v
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if l.Cid == nil {
+				return fmt.Errorf("link #%d without a CID", len(n.Links))
+			}
+			n.Links = append(n.Links, l)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Type < 0 {
+		return nil, fmt.Errorf("no UnixFS data section")
+	}
+	if len(n.BlockSizes) > 0 && len(n.BlockSizes) != len(n.Links) {
+		return nil, fmt.Errorf("%d blocksizes do not match %d links", len(n.BlockSizes), len(n.Links))
+	}
+	return n, nil
+}
+
+// pbFields invokes cb for every varint or length-delimited protobuf field,
+// passing a nil ld for varints
+func pbFields(b []byte, cb func(field int, v uint64, ld []byte) error) error {
+	for len(b) > 0 {
+
+		key, l := binary.Uvarint(b)
+		if l <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		b = b[l:]
+
+		v, l := binary.Uvarint(b)
+		if l <= 0 {
+			return fmt.Errorf("invalid field %d value", key>>3)
+		}
+		b = b[l:]
+
+		var ld []byte
+		switch key & 7 {
+		case wireVarint:
+		case wireLenDlmt:
+			if v > uint64(len(b)) {
+				return fmt.Errorf("truncated field %d", key>>3)
+			}
+			ld = b[:v:v]
+			b = b[v:]
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", key&7, key>>3)
+		}
+
+		if err := cb(int(key>>3), v, ld); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk invokes cb for every block of the DAG under cid in depth-first order,
+// each block exactly once. Inlined (identity) blocks are not reported.
+func Walk(bg BlockGetter, cid []byte, cb func(cid, data []byte) error) error {
+	return walk(bg, cid, cb, make(map[string]struct{}))
+}
+
+func walk(bg BlockGetter, cid []byte, cb func(cid, data []byte) error, seen map[string]struct{}) error {
+
+	if _, done := seen[string(cid)]; done {
+		return nil
+	}
+	seen[string(cid)] = struct{}{}
+
+	data, isInlined, err := GetBlock(bg, cid)
+	if err != nil {
+		return err
+	}
+	if !isInlined {
+		if err := cb(cid, data); err != nil {
+			return err
+		}
+	}
+
+	// only the links matter, leaves need no decompression
+	n, err := Decode(cid, data, Options{})
+	if err != nil {
+		return err
+	}
+	for _, l := range n.Links {
+		if err := walk(bg, l.Cid, cb, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// File presents the payload of a UnixFS file DAG as an io.ReadSeeker.
+// Sequential reads stream the leaves in DFS order, each loaded just once,
+// only a Seek() elsewhere descends from the root anew.
+type File struct {
+	bg   BlockGetter
+	opts Options
+	root *Node
+	size int64
+	pos  int64
+
+	// the DFS path to the node holding cur, valid only at cursorPos
+	stack     []fileFrame
+	cur       []byte
+	cursorPos int64
+}
+
+type fileFrame struct {
+	node *Node
+	next int // index of the link to descend into once cur is exhausted
+}
+
+// NewFile presents the file under root, which opts must have decoded as well
+func NewFile(bg BlockGetter, root *Node, opts Options) (*File, error) {
+	if root.Type != TypeRaw && root.Type != TypeFile {
+		return nil, fmt.Errorf("UnixFS node of type %d is not a file", root.Type)
+	}
+	return &File{bg: bg, opts: opts, root: root, size: int64(root.Size()), cursorPos: -1}, nil
+}
+
+func (f *File) Size() int64 { return f.size }
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return f.pos, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return f.pos, fmt.Errorf("negative seek position %d", offset)
+	}
+	f.pos = offset
+	return f.pos, nil
+}
+
+// Read returns data from at most one leaf per call
+func (f *File) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.cursorPos != f.pos {
+		if err := f.descend(uint64(f.pos)); err != nil {
+			f.cursorPos = -1
+			return 0, err
+		}
+	}
+	for len(f.cur) == 0 {
+		if err := f.advance(); err != nil {
+			f.cursorPos = -1
+			return 0, err
+		}
+	}
+
+	n := copy(p, f.cur)
+	f.cur = f.cur[n:]
+	f.pos += int64(n)
+	f.cursorPos = f.pos
+	return n, nil
+}
+
+// descend positions the cursor at off, starting from the root
+func (f *File) descend(off uint64) error {
+
+	f.stack = f.stack[:0]
+	f.cur = nil
+
+	n := f.root
+	for {
+		if off < uint64(len(n.Data)) {
+			f.stack = append(f.stack, fileFrame{node: n})
+			f.cur = n.Data[off:]
+			return nil
+		}
+		off -= uint64(len(n.Data))
+
+		var child *Node
+		for i := range n.Links {
+
+			var childSize uint64
+			if len(n.BlockSizes) > 0 {
+				childSize = n.BlockSizes[i]
+			} else {
+				// lean links carry no sizes: the child itself has to tell
+				var err error
+				if child, err = Load(f.bg, n.Links[i].Cid, f.opts); err != nil {
+					return err
+				}
+				childSize = child.Size()
+			}
+
+			if off >= childSize {
+				off -= childSize
+				child = nil
+				continue
+			}
+
+			if child == nil {
+				var err error
+				if child, err = Load(f.bg, n.Links[i].Cid, f.opts); err != nil {
+					return err
+				}
+			}
+			f.stack = append(f.stack, fileFrame{node: n, next: i + 1})
+			break
+		}
+
+		if child == nil {
+			return io.ErrUnexpectedEOF
+		}
+		n = child
+	}
+}
+
+// advance moves the cursor onto the data of the next node in DFS order
+func (f *File) advance() error {
+	for len(f.stack) > 0 {
+		top := &f.stack[len(f.stack)-1]
+		if top.next == len(top.node.Links) {
+			f.stack = f.stack[:len(f.stack)-1]
+			continue
+		}
+
+		child, err := Load(f.bg, top.node.Links[top.next].Cid, f.opts)
+		if err != nil {
+			return err
+		}
+		top.next++
+		f.stack = append(f.stack, fileFrame{node: child})
+		f.cur = child.Data
+		return nil
+	}
+
+	// the sizes promised more than the DAG holds
+	return io.ErrUnexpectedEOF
+}
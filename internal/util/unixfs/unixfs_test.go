@@ -0,0 +1,303 @@
+package unixfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/anjor/anelace/internal/encoder/unixfsv1"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type memBlocks map[string][]byte
+
+func (m memBlocks) Get(cid []byte) ([]byte, error) {
+	if b, found := m[string(cid)]; found {
+		return b, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+type countingBlocks struct {
+	memBlocks
+	gets *int
+}
+
+func (c countingBlocks) Get(cid []byte) ([]byte, error) {
+	*c.gets++
+	return c.memBlocks.Get(cid)
+}
+
+// testCid is a sha2-256 shaped CID, the digest merely needs to be unique
+func testCid(codec byte, id int) []byte {
+	cid := append([]byte{0x01, codec, 0x12, 0x20}, make([]byte, 32)...)
+	binary.BigEndian.PutUint64(cid[4:], uint64(id))
+	return cid
+}
+
+func uvarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+func pbVarint(field int, v uint64) []byte {
+	return append(uvarint(uint64(field<<3|wireVarint)), uvarint(v)...)
+}
+
+func pbBytes(field int, ld []byte) []byte {
+	b := append(uvarint(uint64(field<<3|wireLenDlmt)), uvarint(uint64(len(ld)))...)
+	return append(b, ld...)
+}
+
+func pbLink(cid []byte, name string, tsize uint64) []byte {
+	return pbBytes(2, bytes.Join([][]byte{pbBytes(1, cid), pbBytes(2, []byte(name)), pbVarint(3, tsize)}, nil))
+}
+
+// pbFile is a file node over leaves of the given sizes, omitting the
+// blocksizes when lean is set
+func pbFile(data string, leaves [][]byte, sizes []uint64, lean bool) []byte {
+	var n []byte
+	for i, l := range leaves {
+		n = append(n, pbLink(l, "", sizes[i])...)
+	}
+	fsize := uint64(len(data))
+	unixfs := pbVarint(1, TypeFile)
+	if data != "" {
+		unixfs = append(unixfs, pbBytes(2, []byte(data))...)
+	}
+	for _, s := range sizes {
+		fsize += s
+	}
+	unixfs = append(unixfs, pbVarint(3, fsize)...)
+	if !lean {
+		for _, s := range sizes {
+			unixfs = append(unixfs, pbVarint(4, s)...)
+		}
+	}
+	return append(n, pbBytes(1, unixfs)...)
+}
+
+func TestDecode(t *testing.T) {
+
+	leaf := testCid(codecRaw, 1)
+	packedSizes := pbBytes(4, []byte{0x03, 0x04})
+
+	for _, tc := range []struct {
+		name  string
+		cid   []byte
+		block []byte
+		node  *Node
+		err   string
+	}{
+		{"raw leaf", testCid(codecRaw, 0), []byte("payload"), &Node{Type: TypeRaw, Data: []byte("payload")}, ""},
+		{"empty raw leaf", testCid(codecRaw, 0), nil, &Node{Type: TypeRaw}, ""},
+		{"file", testCid(codecPB, 0), pbFile("", [][]byte{leaf}, []uint64{3}, false),
+			&Node{Type: TypeFile, FileSize: 3, BlockSizes: []uint64{3}, Links: []Link{{Cid: leaf, Tsize: 3}}}, ""},
+		{"lean file", testCid(codecPB, 0), pbFile("ab", [][]byte{leaf}, []uint64{3}, true),
+			&Node{Type: TypeFile, Data: []byte("ab"), FileSize: 5, Links: []Link{{Cid: leaf, Tsize: 3}}}, ""},
+		{"packed blocksizes", testCid(codecPB, 0), append(pbLink(leaf, "", 3), append(pbLink(leaf, "", 4), pbBytes(1, append(pbVarint(1, TypeFile), packedSizes...))...)...),
+			&Node{Type: TypeFile, BlockSizes: []uint64{3, 4}, Links: []Link{{Cid: leaf, Tsize: 3}, {Cid: leaf, Tsize: 4}}}, ""},
+		{"directory", testCid(codecPB, 0), append(pbLink(leaf, "name", 3), pbBytes(1, pbVarint(1, TypeDirectory))...),
+			&Node{Type: TypeDirectory, Links: []Link{{Cid: leaf, Name: "name", Tsize: 3}}}, ""},
+		{"unknown fields", testCid(codecPB, 0), append(pbVarint(7, 1), pbBytes(1, append(pbVarint(1, TypeFile), pbBytes(9, nil)...))...),
+			&Node{Type: TypeFile}, ""},
+
+		{"dag-cbor", testCid(0x71, 0), []byte{0xa0}, nil, "unsupported codec 0x71"},
+		{"invalid CID", []byte{0x01, 0x55, 0x12}, nil, nil, "invalid CID varint"},
+		{"no data section", testCid(codecPB, 0), pbLink(leaf, "", 3), nil, "no UnixFS data section"},
+		{"truncated field", testCid(codecPB, 0), pbBytes(1, pbVarint(1, TypeFile))[:3], nil, "truncated field 1"},
+		{"truncated varint", testCid(codecPB, 0), []byte{0x08, 0x80}, nil, "invalid field 1 value"},
+		{"fixed64 field", testCid(codecPB, 0), []byte{0x09, 0, 0, 0, 0, 0, 0, 0, 0}, nil, "unsupported wire type 1"},
+		{"link without a CID", testCid(codecPB, 0), append(pbBytes(2, pbBytes(2, []byte("name"))), pbBytes(1, pbVarint(1, TypeDirectory))...), nil, "link #0 without a CID"},
+		{"blocksizes mismatch", testCid(codecPB, 0), append(pbLink(leaf, "", 3), pbBytes(1, append(pbVarint(1, TypeFile), packedSizes...))...), nil, "2 blocksizes do not match 1 links"},
+		{"invalid packed blocksizes", testCid(codecPB, 0), pbBytes(1, append(pbVarint(1, TypeFile), pbBytes(4, []byte{0x80})...)), nil, "invalid packed blocksizes"},
+	} {
+		n, err := Decode(tc.cid, tc.block, Options{})
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got error %v, expected '%s'", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+			continue
+		}
+		if n.Type != tc.node.Type || !bytes.Equal(n.Data, tc.node.Data) || n.FileSize != tc.node.FileSize ||
+			len(n.BlockSizes) != len(tc.node.BlockSizes) || len(n.Links) != len(tc.node.Links) {
+			t.Errorf("%s: decoded %+v, expected %+v", tc.name, n, tc.node)
+			continue
+		}
+		for i := range n.BlockSizes {
+			if n.BlockSizes[i] != tc.node.BlockSizes[i] {
+				t.Errorf("%s: blocksize #%d is %d, expected %d", tc.name, i, n.BlockSizes[i], tc.node.BlockSizes[i])
+			}
+		}
+		for i, l := range n.Links {
+			if el := tc.node.Links[i]; !bytes.Equal(l.Cid, el.Cid) || l.Name != el.Name || l.Tsize != el.Tsize {
+				t.Errorf("%s: link #%d is %+v, expected %+v", tc.name, i, l, el)
+			}
+		}
+	}
+}
+
+func TestZstdLeaves(t *testing.T) {
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("compressible "), 64)
+	leaf := enc.EncodeAll(payload, append([]byte{}, unixfsv1.ZstdLeafMarker...))
+
+	for _, tc := range []struct {
+		opts     Options
+		block    []byte
+		expected []byte
+	}{
+		// the marker is nothing but leading payload unless asked for
+		{Options{}, leaf, leaf},
+		{Options{ZstdLeaves: true}, leaf, payload},
+		{Options{ZstdLeaves: true}, payload, payload},
+	} {
+		if n, err := Decode(testCid(codecRaw, 0), tc.block, tc.opts); err != nil {
+			t.Errorf("decoding with %+v failed: %s", tc.opts, err)
+		} else if !bytes.Equal(n.Data, tc.expected) {
+			t.Errorf("decoding with %+v returned %d bytes, expected %d", tc.opts, len(n.Data), len(tc.expected))
+		}
+	}
+
+	if _, err := Decode(testCid(codecRaw, 0), append(append([]byte{}, unixfsv1.ZstdLeafMarker...), "garbage"...), Options{ZstdLeaves: true}); err == nil {
+		t.Errorf("invalid zstd leaf decoded successfully")
+	}
+}
+
+// testDag returns a file DAG of "01234567890123ef" with a lean subtree and
+// an inlined leaf, along with the CIDs of its non-inlined blocks in DFS order
+func testDag() (bs memBlocks, root []byte, order [][]byte) {
+
+	bs = make(memBlocks)
+	put := func(cid []byte, b []byte) []byte {
+		bs[string(cid)] = b
+		order = append(order, cid)
+		return cid
+	}
+
+	inlined := []byte{0x01, codecRaw, 0x00, 0x02, 'e', 'f'}
+	root = testCid(codecPB, 0)
+	put(root, nil)
+	l1 := put(testCid(codecRaw, 1), []byte("0123"))
+	sub := put(testCid(codecPB, 2), nil)
+	l2 := put(testCid(codecRaw, 3), []byte("4567"))
+	l3 := put(testCid(codecRaw, 4), []byte("89"))
+
+	bs[string(sub)] = pbFile("", [][]byte{l2, l3, l1}, []uint64{4, 2, 4}, true)
+	// l1 is referenced twice, the second time a Walk() skips it
+	bs[string(root)] = pbFile("", [][]byte{l1, sub, inlined}, []uint64{4, 10, 2}, false)
+
+	return bs, root, order
+}
+
+func TestWalk(t *testing.T) {
+
+	bs, root, order := testDag()
+
+	var seen [][]byte
+	if err := Walk(bs, root, func(cid, _ []byte) error {
+		seen = append(seen, cid)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(order) {
+		t.Fatalf("walked %d blocks, expected %d", len(seen), len(order))
+	}
+	for i := range seen {
+		if !bytes.Equal(seen[i], order[i]) {
+			t.Errorf("block #%d is %x, expected %x", i, seen[i], order[i])
+		}
+	}
+
+	delete(bs, string(order[3]))
+	err := Walk(bs, root, func(_, _ []byte) error { return nil })
+	if mbe, isMissing := err.(*MissingBlockError); !isMissing || !bytes.Equal(mbe.Cid, order[3]) {
+		t.Errorf("expected a MissingBlockError for %x, got %v", order[3], err)
+	}
+}
+
+func TestFile(t *testing.T) {
+
+	const content = "01234567890123ef"
+	bs, root, _ := testDag()
+
+	rn, err := Load(bs, root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFile(bs, rn, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != int64(len(content)) {
+		t.Fatalf("file size %d, expected %d", f.Size(), len(content))
+	}
+
+	for _, tc := range []struct {
+		offset   int64
+		whence   int
+		expected string
+	}{
+		{0, io.SeekStart, content},
+		{5, io.SeekStart, content[5:]},
+		{-3, io.SeekEnd, content[13:]},
+		{0, io.SeekEnd, ""},
+		{4, io.SeekEnd, ""},
+	} {
+		if _, err := f.Seek(tc.offset, tc.whence); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadAll(f); err != nil || string(b) != tc.expected {
+			t.Errorf("reading from %d/%d returned %q %v, expected %q", tc.offset, tc.whence, b, err, tc.expected)
+		}
+	}
+
+	f.Seek(2, io.SeekStart) //nolint:errcheck
+	if pos, err := f.Seek(3, io.SeekCurrent); pos != 5 || err != nil {
+		t.Errorf("relative seek ended at %d %v", pos, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("negative seek succeeded")
+	}
+
+	// a sequential read loads every linked block just once, however small
+	// the reads
+	var gets int
+	counted := countingBlocks{bs, &gets}
+	if f, err = NewFile(counted, rn, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(iotest.OneByteReader(f)); err != nil || string(b) != content {
+		t.Errorf("bytewise read returned %q %v, expected %q", b, err, content)
+	}
+	if gets != 5 {
+		t.Errorf("bytewise read retrieved %d blocks, expected 5", gets)
+	}
+
+	// sizes promising more than the DAG holds
+	short := &Node{Type: TypeFile, FileSize: 20, BlockSizes: []uint64{10}, Links: rn.Links[1:2]}
+	if f, err = NewFile(bs, short, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); err != io.ErrUnexpectedEOF {
+		t.Errorf("reading past the end of the DAG returned %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+
+	if _, err := NewFile(bs, &Node{Type: TypeDirectory}, Options{}); err == nil {
+		t.Errorf("directory accepted as a file")
+	}
+}
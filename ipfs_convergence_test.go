@@ -3,13 +3,19 @@ package anelace
 import (
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"github.com/anjor/anelace/internal/constants"
+	"github.com/anjor/anelace/internal/util/decompress"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // base command => expected cid => file
@@ -164,3 +170,126 @@ func parseConvergenceData(convDataFn string) convergenceTestMatrix {
 
 	return matrix
 }
+
+// The roots kubo assigns to single files added with --preserve-mode and
+// --preserve-mtime, as recorded by maint/misc/kubo_metadata_convergence.bash.
+// A row lacking a CID is a failure: the script has to be re-run against kubo.
+func TestKuboMetadataConvergence(t *testing.T) {
+
+	const fixtures = "maint/misc/kubo_metadata_convergence.tsv"
+	fh, err := os.Open(fixtures)
+	if err != nil {
+		t.Skipf("kubo fixtures unavailable: %s", err)
+	}
+	defer fh.Close() //nolint:errcheck
+
+	tsv := csv.NewReader(fh)
+	tsv.Comma = '\t'
+	records, err := tsv.ReadAll()
+	if err != nil {
+		t.Fatalf("failed reading '%s': %s", fixtures, err)
+	}
+
+	dir, err := ioutil.TempDir("", "anelace-kubo-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	for i, record := range records {
+
+		fields := make(map[string]string)
+		for _, s := range record {
+			pieces := strings.SplitN(s, ":", 2)
+			if len(pieces) == 2 {
+				fields[pieces[0]] = pieces[1]
+			}
+		}
+		t.Run(fmt.Sprintf("%s mode=%s mtime=%s%s", filepath.Base(fields["Data"]), fields["Mode"], fields["Mtime"], fields["Cmd"]), func(t *testing.T) {
+
+			if fields["CID"] == "" {
+				t.Fatalf("row %d of '%s' carries no kubo CID, re-run maint/misc/kubo_metadata_convergence.bash", i+1, fixtures)
+			}
+
+			mtime, err := parseFixtureMtime(fields["Mtime"])
+			if err != nil {
+				t.Fatalf("row %d of '%s': %s", i+1, fixtures, err)
+			}
+
+			fn := filepath.Join(dir, "file")
+			if err := unpackFixture(filepath.Join("maint/misc", fields["Data"]), fn); err != nil {
+				t.Fatal(err)
+			}
+			if fields["Mode"] != "" {
+				mode, err := strconv.ParseUint(fields["Mode"], 8, 32)
+				if err != nil {
+					t.Fatalf("row %d of '%s': invalid mode '%s'", i+1, fixtures, fields["Mode"])
+				}
+				if err := os.Chmod(fn, os.FileMode(mode)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Chtimes(fn, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			roots := testIngest(t, nil, "--emit-stdout=none", "--emit-stderr=none", "--cid-multibase=base32", "--ipfs-add-compatible-command="+fields["Cmd"], fn).roots()
+			if len(roots) != 1 {
+				t.Fatalf("expected a single root, got %d", len(roots))
+			}
+			if roots[0].CidString != fields["CID"] {
+				t.Errorf("expected root %s, got %s", fields["CID"], roots[0].CidString)
+			}
+		})
+	}
+
+	if len(records) == 0 {
+		t.Fatalf("'%s' has no rows", fixtures)
+	}
+}
+
+// parseFixtureMtime parses the "[-]seconds[.fraction]" of touch -d @...
+func parseFixtureMtime(s string) (time.Time, error) {
+	pieces := strings.SplitN(s, ".", 2)
+	sec, err := strconv.ParseInt(pieces[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid mtime '%s'", s)
+	}
+	var nsec int64
+	if len(pieces) == 2 {
+		if len(pieces[1]) > 9 {
+			return time.Time{}, fmt.Errorf("invalid mtime '%s'", s)
+		}
+		if nsec, err = strconv.ParseInt((pieces[1] + "000000000")[:9], 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid mtime '%s'", s)
+		}
+		if strings.HasPrefix(s, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+func unpackFixture(zstFn, fn string) error {
+	in, err := os.Open(zstFn)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck
+
+	r, closer, err := decompress.NewReader(in, "zstd")
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	out, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close() //nolint:errcheck
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,28 @@
+#!/bin/bash
+
+# Regenerates kubo_metadata_convergence.tsv: the roots kubo assigns to files
+# added with --preserve-mode/--preserve-mtime, which TestKuboMetadataConvergence compares
+# against. Run from this directory with a kubo 'ipfs' binary in $PATH.
+
+set -e
+
+adder="ipfs"
+tmp_fn="$(mktemp)"
+trap 'rm -f "$tmp_fn"' EXIT
+
+while IFS=$'\t' read -r data_fld impl_fld mode_fld mtime_fld cmd_fld cid_fld ; do \
+	data_fn="${data_fld#Data:}"
+	mode="${mode_fld#Mode:}"
+	mtime="${mtime_fld#Mtime:}"
+	cmd="${cmd_fld#Cmd:}"
+
+	zstd -qdcf "$data_fn" > "$tmp_fn"
+	[[ -z "$mode" ]] || chmod "$mode" "$tmp_fn"
+	touch -m -d "@$mtime" "$tmp_fn"
+
+	resultCID="$($adder $cmd -n --quiet "$tmp_fn")"
+	echo -e "$data_fld\tImpl:kubo\t$mode_fld\t$mtime_fld\t$cmd_fld\tCID:$resultCID"
+
+done < kubo_metadata_convergence.tsv > kubo_metadata_convergence.tsv.new
+
+mv kubo_metadata_convergence.tsv.new kubo_metadata_convergence.tsv
@@ -0,0 +1,140 @@
+package anelace
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	chunkSizeBuckets    = []float64{4096, 16384, 65536, 131072, 262144, 524288, 1048576, 2097152}
+	hashDurationBuckets = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05}
+)
+
+// ingestMetrics holds whatever --metrics-listen exposes beyond the counters
+// already maintained for the summary
+type ingestMetrics struct {
+	listener     net.Listener
+	carBytes     int64 // atomic
+	uniqueBlocks int64 // protected by anl.mu
+	uniqueBytes  int64 // protected by anl.mu
+	chunkSizes   *histogram
+	hashDuration *histogram
+}
+
+func newIngestMetrics() *ingestMetrics {
+	return &ingestMetrics{
+		chunkSizes:   newHistogram(chunkSizeBuckets),
+		hashDuration: newHistogram(hashDurationBuckets),
+	}
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // one per bound, plus +Inf
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *histogram) observeDuration(d time.Duration) { h.observe(d.Seconds()) }
+
+func (h *histogram) writeTo(w io.Writer, name, help string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum := h.sum
+	h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, strconv.FormatFloat(sum, 'f', -1, 64), name, cumulative)
+}
+
+func (anl *Anelace) startMetricsListener() (argErrs []error) {
+
+	l, err := net.Listen("tcp", anl.cfg.MetricsListen)
+	if err != nil {
+		return []error{fmt.Errorf("unable to listen on --metrics-listen address: %s", err)}
+	}
+	anl.metrics.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", anl.serveMetrics)
+	go http.Serve(l, mux) //nolint:errcheck
+
+	return
+}
+
+// The ingestion does not synchronize the summary counters with us: values
+// are loaded atomically, but may be a hair out of date relative to each other
+func (anl *Anelace) serveMetrics(w http.ResponseWriter, r *http.Request) {
+
+	sys := &anl.statSummary.SysStats
+	m := anl.metrics
+
+	anl.mu.Lock()
+	uniqueBlocks, uniqueBytes, roots := m.uniqueBlocks, m.uniqueBytes, len(anl.statSummary.Roots)
+	anl.mu.Unlock()
+	dagBytes := atomic.LoadInt64(&anl.statSummary.Dag.Size)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	for _, mt := range []struct {
+		name, typ, help string
+		val             float64
+	}{
+		{"anelace_input_bytes_total", "counter", "Payload bytes chunked so far", float64(atomic.LoadInt64(&anl.statSummary.Dag.Payload))},
+		{"anelace_substream_current", "gauge", "Number of the substream being processed", float64(atomic.LoadInt64(&anl.statSummary.Streams))},
+		{"anelace_roots_total", "counter", "Roots emitted so far", float64(roots)},
+		{"anelace_dag_blocks_total", "counter", "Blocks of the logical DAG, including duplicates", float64(atomic.LoadInt64(&anl.statSummary.Dag.Nodes))},
+		{"anelace_dag_bytes_total", "counter", "Wire size of the logical DAG, including duplicates", float64(dagBytes)},
+		{"anelace_unique_blocks_total", "counter", "Distinct blocks seen so far", float64(uniqueBlocks)},
+		{"anelace_unique_bytes_total", "counter", "Wire size of the distinct blocks seen so far", float64(uniqueBytes)},
+		{"anelace_dedup_ratio", "gauge", "Logical DAG size divided by the size of its distinct blocks", dedupRatio(dagBytes, uniqueBytes)},
+		{"anelace_car_bytes_written_total", "counter", "Bytes of car-v1-stream written", float64(atomic.LoadInt64(&m.carBytes))},
+		{"anelace_read_syscalls_total", "counter", "Input read() calls", float64(atomic.LoadInt64(&sys.ReadCalls))},
+		{"anelace_ringbuffer_collector_yields_total", "counter", "Times the ring buffer reader waited for free space", float64(atomic.LoadInt64(&sys.CollectorYields))},
+		{"anelace_ringbuffer_collector_wait_seconds_total", "counter", "Time the ring buffer reader spent waiting for free space, needs --stats-active bit1", float64(atomic.LoadInt64(&sys.CollectorWaitNanoseconds)) / 1e9},
+		{"anelace_ringbuffer_emitter_yields_total", "counter", "Times the chunker waited for input", float64(atomic.LoadInt64(&sys.EmitterYields))},
+		{"anelace_ringbuffer_emitter_wait_seconds_total", "counter", "Time the chunker spent waiting for input, needs --stats-active bit1", float64(atomic.LoadInt64(&sys.EmitterWaitNanoseconds)) / 1e9},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", mt.name, mt.help, mt.name, mt.typ, mt.name, strconv.FormatFloat(mt.val, 'f', -1, 64))
+	}
+
+	m.chunkSizes.writeTo(w, "anelace_chunk_size_bytes", "Sizes of the chunks produced by the chunker chain")
+	m.hashDuration.writeTo(w, "anelace_hash_duration_seconds", "Time spent hashing a single block")
+}
+
+func dedupRatio(dagBytes, uniqueBytes int64) float64 {
+	if uniqueBytes == 0 {
+		return 1
+	}
+	return float64(dagBytes) / float64(uniqueBytes)
+}
@@ -0,0 +1,36 @@
+package anelace
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+
+	rnd := testRand(0)
+	data := testData(rnd, 1024*1024+rnd.Intn(65536))
+
+	anl := testAnelace(t, nil, "--emit-stdout=none", "--emit-stderr=none", "--metrics-listen=127.0.0.1:0", "--chunker=fixed-size_65536")
+	defer anl.Destroy()
+	testProcess(t, anl, bytes.NewReader(data))
+
+	rec := httptest.NewRecorder()
+	anl.serveMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+
+	chunks := (len(data) + 65535) / 65536
+	for _, expected := range []string{
+		"\nanelace_input_bytes_total " + strconv.Itoa(len(data)) + "\n",
+		"\nanelace_chunk_size_bytes_count " + strconv.Itoa(chunks) + "\n",
+		"\nanelace_hash_duration_seconds_count ",
+		"\nanelace_dedup_ratio 1\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("metrics lack %q:\n%s", expected, out)
+		}
+	}
+}
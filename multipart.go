@@ -0,0 +1,103 @@
+package anelace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/anjor/anelace/internal/encoder"
+	"io"
+	"time"
+)
+
+// With --multipart-tlv every substream is preceded by any number of
+// type-length-value fields, each a 1-byte type, an SInt64BE length and that
+// many bytes of value. The payload field comes last: its value is the
+// substream itself. Unknown field types are skipped.
+//
+// The mode is a UInt32BE of unix permission bits, the mtime an SInt64BE of
+// seconds since the epoch, optionally followed by UInt32BE nanoseconds.
+const (
+	tlvPayload = byte(0x00)
+	tlvName    = byte(0x01)
+	tlvMeta    = byte(0x02)
+	tlvMode    = byte(0x03)
+	tlvMtime   = byte(0x04)
+
+	maxTLVFieldSize = 1 << 20
+)
+
+type substreamHeader struct {
+	name     string
+	meta     []byte
+	rootMeta anlencoder.RootMetadata
+}
+
+// readSubstreamHeader returns io.EOF only when the input ended cleanly in
+// place of a new substream
+func (anl *Anelace) readSubstreamHeader(r io.Reader) (size int64, hdr substreamHeader, err error) {
+
+	if !anl.cfg.MultipartTLV {
+		if err = binary.Read(r, binary.BigEndian, &size); err == nil {
+			anl.multipartOffset += 8
+			if size < 0 {
+				err = fmt.Errorf("invalid substream size %d", size)
+			}
+		}
+		return
+	}
+
+	for first := true; ; first = false {
+
+		var tl [9]byte
+		if _, err = io.ReadFull(r, tl[:]); err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		anl.multipartOffset += int64(len(tl))
+
+		typ, l := tl[0], int64(binary.BigEndian.Uint64(tl[1:]))
+		if l < 0 {
+			return 0, hdr, fmt.Errorf("invalid length %d of field type 0x%02x", l, typ)
+		}
+		if typ == tlvPayload {
+			return l, hdr, nil
+		}
+		if l > maxTLVFieldSize {
+			return 0, hdr, fmt.Errorf("field type 0x%02x of %d bytes exceeds the maximum of %d", typ, l, maxTLVFieldSize)
+		}
+
+		val := make([]byte, l)
+		if _, err = io.ReadFull(r, val); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		anl.multipartOffset += l
+
+		switch typ {
+		case tlvName:
+			hdr.name = string(val)
+		case tlvMeta:
+			hdr.meta = val
+		case tlvMode:
+			if l != 4 {
+				return 0, hdr, fmt.Errorf("invalid mode field of %d bytes", l)
+			}
+			hdr.rootMeta.Mode = binary.BigEndian.Uint32(val)
+			hdr.rootMeta.HasMode = true
+		case tlvMtime:
+			if l != 8 && l != 12 {
+				return 0, hdr, fmt.Errorf("invalid mtime field of %d bytes", l)
+			}
+			var nsec int64
+			if l == 12 {
+				if nsec = int64(binary.BigEndian.Uint32(val[8:])); nsec > 999999999 {
+					return 0, hdr, fmt.Errorf("invalid mtime nanoseconds %d", nsec)
+				}
+			}
+			hdr.rootMeta.Mtime = time.Unix(int64(binary.BigEndian.Uint64(val)), nsec)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMultipartTLV(t *testing.T) {
+
+	rnd := testRand(0)
+
+	field := func(b *bytes.Buffer, typ byte, val []byte) {
+		b.WriteByte(typ)
+		binary.Write(b, binary.BigEndian, int64(len(val))) //nolint:errcheck
+		b.Write(val)
+	}
+
+	substreams := []struct {
+		name string
+		meta []byte
+		data []byte
+	}{
+		{"bucket/key one", []byte(`{"etag":"abc"}`), testData(rnd, rnd.Intn(300*1024))},
+		{"", nil, testData(rnd, rnd.Intn(300*1024))},
+		{"empty", []byte{0, 1, 2}, nil},
+	}
+
+	var tlv bytes.Buffer
+	var plain [][]byte
+	for _, s := range substreams {
+		if s.name != "" {
+			field(&tlv, tlvName, []byte(s.name))
+		}
+		field(&tlv, 0x7f, []byte("skipped, unknown"))
+		if s.meta != nil {
+			field(&tlv, tlvMeta, s.meta)
+		}
+		field(&tlv, tlvPayload, s.data)
+		plain = append(plain, s.data)
+	}
+
+	roots := func(framing string, input []byte) []rootStats {
+		return testIngest(t, bytes.NewReader(input), "--emit-stdout=none", "--emit-stderr=none", framing).anl.statSummary.Roots
+	}
+
+	tlvRoots, plainRoots := roots("--multipart-tlv", tlv.Bytes()), roots("--multipart", testMultipart(plain...))
+	if len(tlvRoots) != len(substreams) || len(plainRoots) != len(substreams) {
+		t.Fatalf("expected %d roots, got %d and %d", len(substreams), len(tlvRoots), len(plainRoots))
+	}
+	for i, s := range substreams {
+		r := tlvRoots[i]
+		if r.Cid != plainRoots[i].Cid || r.Name != s.name || !bytes.Equal(r.Meta, s.meta) {
+			t.Errorf("root #%d %+v does not match substream %q / %q / %s", i+1, r, s.name, s.meta, plainRoots[i].Cid)
+		}
+	}
+}
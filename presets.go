@@ -0,0 +1,137 @@
+package anelace
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/util/text"
+	"io"
+	"sort"
+)
+
+type preset struct {
+	description string
+	args        []string
+}
+
+// Every preset spells out all CID-determining options, so that the result
+// does not depend on the defaults of whichever anelace version is in use
+var availablePresets = map[string]preset{
+	"kubo-default": {
+		description: "`ipfs add` with no options: CIDv0, 256KiB chunks, unixfs-wrapped leaves, 174 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_262144",
+			"--collector=fixed-outdegree_max-outdegree=174",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf_cidv0_unixfs-leaf-decorator-type=2",
+		},
+	},
+	"kubo-test-cid-v1": {
+		description: "kubo `test-cid-v1` import profile: CIDv1, 1MiB chunks, raw leaves, 174 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_1048576",
+			"--collector=fixed-outdegree_max-outdegree=174",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf",
+		},
+	},
+	"kubo-test-cid-v1-wide": {
+		description: "kubo `test-cid-v1-wide` import profile: CIDv1, 1MiB chunks, raw leaves, 1024 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_1048576",
+			"--collector=fixed-outdegree_max-outdegree=1024",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf",
+		},
+	},
+	"js-ipfs": {
+		description: "js-ipfs `ipfs.add()` defaults: CIDv0, 256KiB chunks, unixfs-wrapped leaves, 174 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_262144",
+			"--collector=fixed-outdegree_max-outdegree=174",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf_cidv0_unixfs-leaf-decorator-type=2",
+		},
+	},
+	"web3storage": {
+		description: "web3.storage (w3up) client uploads: CIDv1, 1MiB chunks, raw leaves, 1024 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_1048576",
+			"--collector=fixed-outdegree_max-outdegree=1024",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf",
+		},
+	},
+	"filecoin-lotus-client-import": {
+		description: "`lotus client import`: CIDv1, 1MiB chunks, raw leaves, 1024 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_1048576",
+			"--collector=fixed-outdegree_max-outdegree=1024",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf",
+		},
+	},
+	"nft-storage": {
+		description: "nft.storage client (ipfs-car) uploads: CIDv1, 256KiB chunks, raw leaves, 174 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_262144",
+			"--collector=fixed-outdegree_max-outdegree=174",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf",
+		},
+	},
+	"estuary": {
+		description: "Estuary content imports: CIDv1, 1MiB chunks, raw leaves, 174 links per node",
+		args: []string{
+			"--inline-max-size=0",
+			"--hash=sha2-256",
+			"--hash-bits=256",
+			"--chunker=fixed-size_1048576",
+			"--collector=fixed-outdegree_max-outdegree=174",
+			"--node-encoder=unixfsv1_merkledag-compat-protobuf",
+		},
+	},
+}
+
+// expandPreset lists the arguments of the named preset, including itself so
+// that it remains on record once they are parsed
+func expandPreset(name string) ([]string, error) {
+	p, found := availablePresets[name]
+	if !found {
+		return nil, fmt.Errorf(
+			"Preset '%s' requested via '--preset' is not valid. Available presets are %s",
+			name,
+			text.AvailableMapKeys(availablePresets),
+		)
+	}
+	return append([]string{"--preset=" + name}, p.args...), nil
+}
+
+func printPresetUsage(out io.Writer) {
+	names := make([]string, 0, len(availablePresets))
+	for n := range availablePresets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	fmt.Fprint(out, "\n")
+	for _, n := range names {
+		fmt.Fprintf(out, "[P]reset '%s'\n  %s\n", n, availablePresets[n].description)
+		for _, a := range availablePresets[n].args {
+			fmt.Fprintf(out, "     %s\n", a)
+		}
+		fmt.Fprint(out, "\n")
+	}
+}
@@ -0,0 +1,141 @@
+package anelace
+
+import (
+	"fmt"
+	"github.com/anjor/anelace/internal/util/stream"
+	"github.com/anjor/anelace/internal/util/text"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultProgressInterval = time.Second
+
+// counts the raw input as consumed by the ring buffer (or decompressor),
+// which is what SetExpectedInputSize() refers to
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
+
+// SetProgressInterval enables Progress events on the ProcessReader() event
+// channel every d, 0 disables. With --progress the same reports are also
+// printed on stdERR.
+func (anl *Anelace) SetProgressInterval(d time.Duration) {
+	anl.progressInterval = d
+}
+
+// SetExpectedInputSize makes progress reports include an ETA. The size is
+// that of the raw input, before any --input-decompress.
+func (anl *Anelace) SetExpectedInputSize(size int64) {
+	anl.expectedInputSize = size
+}
+
+type ProgressEvent struct {
+	InputBytes     int64   `json:"inputBytes"`
+	ExpectedBytes  int64   `json:"expectedInputBytes,omitempty"`
+	Payload        int64   `json:"payload"`
+	Stream         int64   `json:"stream"`
+	Blocks         int64   `json:"blocks"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	EtaSeconds     float64 `json:"etaSeconds,omitempty"`
+}
+
+func (anl *Anelace) currentProgress(t0 time.Time) (pr ProgressEvent) {
+	pr.InputBytes = atomic.LoadInt64(&anl.inputBytesRead)
+	pr.ExpectedBytes = anl.expectedInputSize
+	pr.Payload = atomic.LoadInt64(&anl.statSummary.Dag.Payload)
+	pr.Stream = atomic.LoadInt64(&anl.statSummary.Streams)
+	pr.Blocks = atomic.LoadInt64(&anl.statSummary.Dag.Nodes)
+
+	if elapsed := time.Since(t0).Seconds(); elapsed > 0 {
+		pr.BytesPerSecond = float64(pr.InputBytes) / elapsed
+	}
+	if pr.ExpectedBytes > pr.InputBytes && pr.BytesPerSecond > 0 {
+		pr.EtaSeconds = float64(pr.ExpectedBytes-pr.InputBytes) / pr.BytesPerSecond
+	}
+	return
+}
+
+// startProgressReporter returns the function stopping the reporting, which
+// must be called before the event channel is closed
+func (anl *Anelace) startProgressReporter(t0 time.Time) (stop func()) {
+
+	if anl.progressInterval <= 0 || (anl.progressOut == nil && anl.externalEventBus == nil) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	// overwrite the same line on a terminal, one line per report otherwise
+	lineEnd := "\n"
+	if stream.IsTTY(anl.progressOut) {
+		lineEnd = "\r"
+	}
+
+	go func() {
+		defer wg.Done()
+
+		tick := time.NewTicker(anl.progressInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-done:
+				if lineEnd == "\r" {
+					fmt.Fprint(anl.progressOut, "\n")
+				}
+				return
+			case <-tick.C:
+			}
+
+			pr := anl.currentProgress(t0)
+
+			if anl.externalEventBus != nil {
+				// a stale progress event is worthless: never block the ingestion on it
+				select {
+				case anl.externalEventBus <- IngestionEvent{Type: Progress, Body: jsonlEvent("progress", &pr), Data: &pr}:
+				default:
+				}
+			}
+			if anl.progressOut != nil {
+				fmt.Fprintf(anl.progressOut, "%s%s", pr.text(), lineEnd)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (pr ProgressEvent) text() string {
+
+	var total, eta string
+	if pr.ExpectedBytes > 0 {
+		total = fmt.Sprintf(" of %s (%.1f%%)", text.Commify64(pr.ExpectedBytes), 100*float64(pr.InputBytes)/float64(pr.ExpectedBytes))
+		if pr.EtaSeconds > 0 {
+			eta = fmt.Sprintf(", ETA %s", time.Duration(pr.EtaSeconds*float64(time.Second)).Round(time.Second))
+		}
+	}
+
+	return fmt.Sprintf(
+		"Read %s bytes%s at %.2f MiB/s, substream #%d, %s blocks%s    ",
+		text.Commify64(pr.InputBytes),
+		total,
+		pr.BytesPerSecond/(1024*1024),
+		pr.Stream,
+		text.Commify64(pr.Blocks),
+		eta,
+	)
+}
@@ -0,0 +1,52 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+type slowReader struct{ r io.Reader }
+
+func (sr slowReader) Read(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	if len(p) > 64*1024 {
+		p = p[:64*1024]
+	}
+	return sr.r.Read(p)
+}
+
+func TestProgressEvents(t *testing.T) {
+
+	data := make([]byte, 2*1024*1024)
+
+	anl := testAnelace(t, nil, "--emit-stdout=none", "--emit-stderr=none")
+	defer anl.Destroy()
+	anl.SetProgressInterval(10 * time.Millisecond)
+	anl.SetExpectedInputSize(int64(len(data)))
+
+	var reports []ProgressEvent
+	for _, ev := range testProcess(t, anl, slowReader{bytes.NewReader(data)}) {
+		if ev.Type == Progress {
+			var pr ProgressEvent
+			if err := json.Unmarshal([]byte(ev.Body), &pr); err != nil {
+				t.Fatalf("unparseable progress event %s: %s", ev.Body, err)
+			}
+			if pr != *ev.Data.(*ProgressEvent) {
+				t.Fatalf("progress event body %s does not match %+v", ev.Body, ev.Data)
+			}
+			reports = append(reports, pr)
+		}
+	}
+	if len(reports) < 2 {
+		t.Fatalf("expected several progress events, got %d", len(reports))
+	}
+	for i, pr := range reports {
+		if pr.ExpectedBytes != int64(len(data)) || pr.InputBytes > pr.ExpectedBytes ||
+			(i > 0 && pr.InputBytes < reports[i-1].InputBytes) {
+			t.Errorf("unexpected progress report #%d %+v", i, pr)
+		}
+	}
+}
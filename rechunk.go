@@ -0,0 +1,227 @@
+package anelace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/anjor/anelace/internal/block"
+	"github.com/anjor/anelace/internal/transform/convergent"
+	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/car"
+	"github.com/anjor/anelace/internal/util/multibase"
+	"github.com/anjor/anelace/internal/util/unixfs"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/pborman/options"
+)
+
+type rechunkConfig struct {
+	Help       bool     `getopt:"-h --help       Display basic help"`
+	Cars       []string `getopt:"--car=filename  A .car file holding the DAGs to rechunk, can be given multiple times"`
+	Roots      []string `getopt:"--root=cid      A UnixFS file DAG to rechunk, can be given multiple times. Default: every block of the given CARs not linked to by any other, bar key manifests"`
+	ZstdLeaves bool     `getopt:"--experimental-zstd-leaves  Decompress raw leaves stored via the ingestion option of the same name. Misreads any other leaf starting with the same marker"`
+}
+
+// Rechunk reassembles the UnixFS files contained in CARs and ingests them
+// anew, without ever materializing them on disk. argv[0] is the subcommand
+// name, any parameters following a "--" are ingestion options. Every file is
+// a --multipart-tlv substream named by the CIDv1 of its original root (as
+// stored in a car-v1-stream), so each new root in roots-jsonl carries the old
+// one it replaces. Roots inlined as identity CIDs never appear in a CAR, and
+// are only found when given via --root.
+//
+//	anelace rechunk --car=old.car -- --chunker=buzhash --emit-stdout=car-v1-stream --emit-stderr=roots-jsonl
+func Rechunk(argv []string) error {
+	return rechunk(argv, os.Stderr, os.Stdout)
+}
+
+func rechunk(argv []string, stderr io.Writer, stdout io.Writer) error {
+
+	var cfg rechunkConfig
+
+	optSet := getopt.New()
+	if err := options.RegisterSet("", &cfg, optSet); err != nil {
+		return fmt.Errorf("option set registration failed: %s", err)
+	}
+	optSet.SetParameters("-- [ingestion options]")
+
+	ingestArgs, errs := argparser.ParseWithParameters(argv, optSet)
+	if len(errs) > 0 {
+		optSet.PrintUsage(argParseErrOut)
+		return fmt.Errorf("%s", strings.Join(getErrrStrings(errs), "\n\t"))
+	}
+	if cfg.Help {
+		optSet.PrintUsage(argParseErrOut)
+		return nil
+	}
+
+	if len(cfg.Cars) == 0 {
+		return fmt.Errorf("at least one --car must be specified")
+	}
+
+	anl, errs := NewAnelaceFromArgvWithWriters(
+		append([]string{argv[0], "--multipart-tlv"}, ingestArgs...),
+		stderr,
+		stdout,
+	)
+	defer anl.Destroy()
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid ingestion options:\n\t%s", strings.Join(getErrrStrings(errs), "\n\t"))
+	}
+	if len(anl.InputFiles()) > 0 {
+		return fmt.Errorf("unexpected input file parameters: the input is the content of --car")
+	}
+
+	gb := &gatewayBlocks{carIndex: make(map[string]carBlockLoc)}
+	defer func() {
+		for _, fh := range gb.cars {
+			fh.Close() //nolint:errcheck
+		}
+	}()
+	for _, fn := range cfg.Cars {
+		if err := gb.indexCar(fn); err != nil {
+			return err
+		}
+	}
+
+	// the old roots are named in the same multibase as the new ones
+	mb, _ := multibase.Lookup(anl.cfg.CidMultibase)
+
+	var roots [][]byte
+	var names []string
+	if len(cfg.Roots) > 0 {
+		for _, r := range cfg.Roots {
+			cid, err := multibase.Decode(r)
+			if err == nil {
+				_, _, _, err = car.ParseCid(cid)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid --root '%s': %s", r, err)
+			}
+			roots = append(roots, cid)
+			names = append(names, mb.Encode(car.CidV1(cid)))
+		}
+	} else {
+		var err error
+		if roots, err = unreferencedBlocks(cfg.Cars); err != nil {
+			return err
+		}
+		for _, cid := range roots {
+			names = append(names, mb.Encode(cid))
+		}
+	}
+
+	opts := unixfs.Options{ZstdLeaves: cfg.ZstdLeaves}
+	files := make([]*unixfs.File, len(roots))
+	var expectedSize int64
+	for i := range roots {
+		n, err := unixfs.Load(gb, roots[i], opts)
+		if err == nil {
+			files[i], err = unixfs.NewFile(gb, n, opts)
+		}
+		if err != nil {
+			return fmt.Errorf("root %s: %s", names[i], err)
+		}
+		expectedSize += 9 + int64(len(names[i])) + 9 + files[i].Size()
+	}
+	anl.SetExpectedInputSize(expectedSize)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeRechunkStream(pw, files, names)) //nolint:errcheck
+	}()
+
+	err := anl.ProcessReader(pr, nil)
+	pr.CloseWithError(io.ErrClosedPipe) //nolint:errcheck
+	if err != nil {
+		return err
+	}
+
+	anl.OutputSummary()
+	return nil
+}
+
+func writeRechunkStream(w io.Writer, files []*unixfs.File, names []string) error {
+
+	field := func(typ byte, size int64) error {
+		var tl [9]byte
+		tl[0] = typ
+		binary.BigEndian.PutUint64(tl[1:], uint64(size))
+		_, err := w.Write(tl[:])
+		return err
+	}
+
+	for i, f := range files {
+		if err := field(tlvName, int64(len(names[i]))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, names[i]); err != nil {
+			return err
+		}
+		if err := field(tlvPayload, f.Size()); err != nil {
+			return err
+		}
+		if n, err := io.Copy(w, f); err != nil {
+			return fmt.Errorf("reassembling %s failed: %s", names[i], err)
+		} else if n != f.Size() {
+			return fmt.Errorf("reassembling %s yielded %d bytes instead of %d", names[i], n, f.Size())
+		}
+	}
+	return nil
+}
+
+// unreferencedBlocks lists, in order of appearance, every block of the CARs
+// no other block links to: the roots of the DAGs within. The key manifests of
+// --leaf-transform=convergent are never linked to, and are not roots either
+func unreferencedBlocks(cars []string) (roots [][]byte, err error) {
+
+	var order [][]byte
+	referenced := make(map[string]bool)
+
+	for _, fn := range cars {
+		fh, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		cr := car.NewReader(fh)
+		for {
+			cid, data, err := cr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				fh.Close() //nolint:errcheck
+				return nil, fmt.Errorf("failed reading '%s': %s", fn, err)
+			}
+
+			cid = car.CidV1(cid)
+			if cid[1] == byte(anlblock.CodecRaw) && convergent.IsKeyManifest(data) {
+				continue
+			}
+
+			n, err := unixfs.Decode(cid, data, unixfs.Options{})
+			if err != nil {
+				fh.Close() //nolint:errcheck
+				return nil, fmt.Errorf("failed reading '%s': %s", fn, err)
+			}
+
+			if _, seen := referenced[string(cid)]; !seen {
+				referenced[string(cid)] = false
+				order = append(order, cid)
+			}
+			for _, l := range n.Links {
+				referenced[string(car.CidV1(l.Cid))] = true
+			}
+		}
+		fh.Close() //nolint:errcheck
+	}
+
+	for _, cid := range order {
+		if !referenced[string(cid)] {
+			roots = append(roots, cid)
+		}
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package anelace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/anjor/anelace/internal/util/multibase"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRechunk(t *testing.T) {
+
+	rnd := testRand(0)
+
+	dir, err := ioutil.TempDir("", "anelace-rechunk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	multipart := testMultipart(
+		testData(rnd, 1024*1024+rnd.Intn(1024*1024)),
+		testData(rnd, 4096+rnd.Intn(64*1024)),
+	)
+
+	ingest := func(argv ...string) (roots []*RootEvent, car []byte) {
+		r := testIngest(t, bytes.NewReader(multipart), append([]string{"--multipart", "--emit-stdout=car-v1-stream", "--emit-stderr=none", "--cid-multibase=base58btc"}, argv...)...)
+		return r.roots(), r.stdout
+	}
+
+	newArgs := []string{"--chunker=buzhash_hash-table=GoIPFSv0_state-target=0_state-mask-bits=15_min-size=8192_max-size=131072", "--collector=prolly_target-outdegree=16"}
+
+	oldRoots, oldCar := ingest("--chunker=fixed-size_262144")
+	expectedRoots, expectedCar := ingest(newArgs...)
+
+	oldFn := filepath.Join(dir, "old.car")
+	if err := ioutil.WriteFile(oldFn, oldCar, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var newCar, mapping bytes.Buffer
+	if err := rechunk(
+		append([]string{"rechunk", "--car=" + oldFn, "--", "--emit-stdout=car-v1-stream", "--emit-stderr=roots-jsonl", "--cid-multibase=base58btc"}, newArgs...),
+		&mapping,
+		&newCar,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream is identical to ingesting the original data directly
+	if !bytes.Equal(newCar.Bytes(), expectedCar) {
+		t.Error("rechunked car differs from a direct ingestion")
+	}
+
+	var i int
+	sc := bufio.NewScanner(&mapping)
+	for sc.Scan() {
+		var r struct {
+			Cid  string `json:"cid"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(oldRoots) {
+			t.Fatalf("unexpected extra root %s", sc.Text())
+		}
+		if r.Name != oldRoots[i].CidString || r.Cid != expectedRoots[i].CidString {
+			t.Errorf("mapping #%d %s => %s, expected %s => %s", i+1, r.Name, r.Cid, oldRoots[i].CidString, expectedRoots[i].CidString)
+		}
+		i++
+	}
+	if i != len(oldRoots) {
+		t.Errorf("expected %d roots in the mapping, got %d", len(oldRoots), i)
+	}
+
+	// a --root in another multibase is named the same as a discovered one
+	cid, err := multibase.Decode(oldRoots[1].CidString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b32, _ := multibase.Lookup("base32")
+	mapping.Reset()
+	if err := rechunk(
+		append([]string{"rechunk", "--car=" + oldFn, "--root=" + b32.Encode(cid), "--", "--emit-stdout=none", "--emit-stderr=roots-jsonl", "--cid-multibase=base58btc"}, newArgs...),
+		&mapping,
+		ioutil.Discard,
+	); err != nil {
+		t.Fatal(err)
+	}
+	var r struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(mapping.Bytes(), &r); err != nil || r.Name != oldRoots[1].CidString {
+		t.Errorf("--root named %s instead of %s %v", r.Name, oldRoots[1].CidString, err)
+	}
+
+	// key manifests are not linked to, yet are not roots
+	encRoots, encCar := ingest("--chunker=fixed-size_262144", "--leaf-transform=convergent")
+	encFn := filepath.Join(dir, "encrypted.car")
+	if err := ioutil.WriteFile(encFn, encCar, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := unreferencedBlocks([]string{encFn}); err != nil || len(found) != len(encRoots) {
+		t.Errorf("expected %d roots in a car with key manifests, found %d %v", len(encRoots), len(found), err)
+	}
+
+	// malformed CARs are an error, not a crash
+	for name, content := range map[string][]byte{
+		"truncated":       oldCar[:len(oldCar)-1],
+		"wrapping digest": {0x01, 0xa0, 0x0e, 0x01, 0x55, 0x12, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0x00},
+		"corrupt dag-pb":  append(oldCar[:len(oldCar):len(oldCar)], 0x06, 0x01, 0x70, 0x00, 0x00, 0x0a, 0x1e),
+	} {
+		fn := filepath.Join(dir, "malformed.car")
+		if err := ioutil.WriteFile(fn, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rechunk([]string{"rechunk", "--car=" + fn, "--", "--emit-stdout=none", "--emit-stderr=none"}, ioutil.Discard, ioutil.Discard); err == nil {
+			t.Errorf("rechunking a %s car succeeded", name)
+		}
+	}
+}
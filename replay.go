@@ -0,0 +1,152 @@
+package anelace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/anjor/anelace/internal/constants"
+	"os"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// the only options that may differ from the replayed summary: none of them
+// can influence the resulting CIDs
+var replayOverridable = map[string]bool{
+	"replay-from":             true,
+	"replay-verify":           true,
+	"emit-stdout":             true,
+	"emit-stderr":             true,
+	"async-hashers":           true,
+	"async-hashers-budget":    true,
+	"ring-buffer-size":        true,
+	"ring-buffer-sync-size":   true,
+	"ring-buffer-min-sysread": true,
+	"input-mmap":              true,
+	"stats-active":            true,
+	"metrics-listen":          true,
+	"progress":                true,
+	"checkpoint":              true,
+	"checkpoint-interval":     true,
+	"resume":                  true,
+	"files-from":              true,
+}
+
+// loadReplaySummary accepts either a lone summary object, or an entire
+// stats-jsonl stream, in which case the last summary event is used
+func loadReplaySummary(fn string) (*statSummary, error) {
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open --replay-from summary: %s", err)
+	}
+	defer fh.Close() //nolint:errcheck
+
+	var smr *statSummary
+	dec := json.NewDecoder(bufio.NewReader(fh))
+	for dec.More() {
+		var s statSummary
+		if err := dec.Decode(&s); err != nil {
+			return nil, fmt.Errorf("unable to parse --replay-from summary '%s': %s", fn, err)
+		}
+		if s.EventType == "summary" {
+			smr = &s
+		}
+	}
+
+	if smr == nil {
+		return nil, fmt.Errorf("no summary event found in --replay-from file '%s'", fn)
+	}
+	return smr, nil
+}
+
+// replayArgs returns the argvExpanded of the replayed summary, minus the
+// options overridden on the command line, and the roots to verify against
+func (cfg *config) replayArgs() (args []string, roots []string, err error) {
+
+	if cfg.optSet.IsSet("preset") || cfg.optSet.IsSet("config") || cfg.optSet.IsSet("ipfs-add-compatible-command") {
+		return nil, nil, fmt.Errorf("--replay-from can not be combined with --preset, --config or --ipfs-add-compatible-command")
+	}
+
+	smr, err := loadReplaySummary(cfg.ReplayFrom)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if smr.SysStats.CidCompatVersion != constants.CidCompatVersion {
+		return nil, nil, fmt.Errorf(
+			"summary '%s' was produced with CID compatibility version %d, while this anelace is at version %d: refusing to replay",
+			cfg.ReplayFrom,
+			smr.SysStats.CidCompatVersion,
+			constants.CidCompatVersion,
+		)
+	}
+	if len(smr.SysStats.ArgvExpanded) == 0 {
+		return nil, nil, fmt.Errorf("summary '%s' contains no argvExpanded", cfg.ReplayFrom)
+	}
+
+	var argErrs []string
+	for _, a := range smr.SysStats.ArgvExpanded {
+		n := strings.SplitN(strings.TrimPrefix(a, "--"), "=", 2)[0]
+		if !cfg.optSet.IsSet(n) {
+			args = append(args, a)
+		}
+	}
+	cfg.optSet.VisitAll(func(o getopt.Option) {
+		if o.Seen() && !replayOverridable[o.LongName()] {
+			argErrs = append(argErrs, "--"+o.LongName())
+		}
+	})
+	if len(argErrs) > 0 {
+		return nil, nil, fmt.Errorf("option(s) %s may not be changed when using --replay-from", strings.Join(argErrs, ", "))
+	}
+
+	if cfg.ReplayVerify {
+		if len(smr.Roots) == 0 {
+			return nil, nil, fmt.Errorf("summary '%s' lists no roots to --replay-verify against", cfg.ReplayFrom)
+		}
+		roots = make([]string, len(smr.Roots))
+		for i := range smr.Roots {
+			roots[i] = smr.Roots[i].Cid
+		}
+	}
+
+	return args, roots, nil
+}
+
+// VerifyReplay compares the roots produced so far against the ones listed in
+// the --replay-from summary. It is a no-op without --replay-verify.
+func (anl *Anelace) VerifyReplay() error {
+
+	expected := anl.cfg.replayRoots
+	if expected == nil {
+		return nil
+	}
+
+	anl.mu.Lock()
+	defer anl.mu.Unlock()
+
+	for i := range anl.statSummary.Roots {
+		if i >= len(expected) {
+			return fmt.Errorf("replay produced more than the %d roots listed in the summary", len(expected))
+		}
+		if anl.statSummary.Roots[i].Cid != expected[i] {
+			return fmt.Errorf(
+				"replay root #%d %s does not match the expected %s",
+				i+1,
+				anl.statSummary.Roots[i].Cid,
+				expected[i],
+			)
+		}
+	}
+	if len(anl.statSummary.Roots) < len(expected) {
+		return fmt.Errorf(
+			"replay produced only %d out of the %d roots listed in the summary",
+			len(anl.statSummary.Roots),
+			len(expected),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,359 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/anjor/anelace/internal/util/argparser"
+	"github.com/anjor/anelace/internal/util/blockstore"
+	"github.com/anjor/anelace/internal/util/car"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+	"github.com/pborman/options"
+)
+
+type serveConfig struct {
+	Help            bool   `getopt:"-h --help                      Display basic help"`
+	Listen          string `getopt:"--listen=addr                  Address for the HTTP API to listen on. Default:"`
+	MaxConcurrent   int    `getopt:"--max-concurrent-ingests=integer Amount of requests processed simultaneously, the rest wait for their turn. Default:"`
+	MaxAsyncHashers int    `getopt:"--max-async-hashers=integer    Upper limit of the async-hashers a single request may ask for. Default:"`
+	MaxRequestSize  int64  `getopt:"--max-request-size=bytes      Reject request bodies larger than this, 0 disables but also refuses ?car=true. Default:"`
+	BlockstoreDir   string `getopt:"--blockstore-dir=path          Directory receiving the blocks of requests made with ?blockstore=true"`
+	ConfigFile      string `getopt:"--config=filename              A --config file providing the defaults for every request"`
+}
+
+// options a request may set via its query string, everything else is fixed
+// by the server configuration. Plugin options can not refer to server-side
+// files or environment.
+var serveOverridable = map[string]bool{
+	"preset":           true,
+	"inline-max-size":  true,
+	"hash":             true,
+	"hash-bits":        true,
+	"chunker":          true,
+	"leaf-transform":   true,
+	"collector":        true,
+	"node-encoder":     true,
+	"cid-multibase":    true,
+	"input-decompress": true,
+	"multipart":        true,
+	"multipart-tlv":    true,
+	"skip-nul-inputs":  true,
+	"async-hashers":    true,
+}
+
+type server struct {
+	cfg        serveConfig
+	slots      chan struct{}
+	blockstore *blockstore.Dir
+}
+
+// Serve runs the HTTP ingestion API until a fatal error. argv[0] is the
+// subcommand name.
+//
+//	POST /ingest    body is the data to ingest, the response is the stats-jsonl summary
+//	  ?car=true         respond with the CAR stream instead, the summary arrives in the X-Anelace-Summary trailer
+//	                    (the body is spooled to disk first, so --max-request-size may not be 0)
+//	  ?blockstore=true  additionally store all blocks in --blockstore-dir
+//	  ?<option>=<value> any of the per-request overridable options
+func Serve(argv []string) error {
+
+	s := &server{cfg: serveConfig{
+		Listen:          "127.0.0.1:8085",
+		MaxConcurrent:   runtime.NumCPU(),
+		MaxAsyncHashers: runtime.NumCPU(),
+		MaxRequestSize:  1 << 30,
+	}}
+
+	optSet := getopt.New()
+	if err := options.RegisterSet("", &s.cfg, optSet); err != nil {
+		return fmt.Errorf("option set registration failed: %s", err)
+	}
+	optSet.SetParameters("")
+
+	if errs := argparser.Parse(argv, optSet); len(errs) > 0 {
+		optSet.PrintUsage(argParseErrOut)
+		return fmt.Errorf("%s", strings.Join(getErrrStrings(errs), "\n\t"))
+	}
+	if s.cfg.Help {
+		optSet.PrintUsage(argParseErrOut)
+		return nil
+	}
+
+	if s.cfg.MaxConcurrent < 1 {
+		return fmt.Errorf("--max-concurrent-ingests must be at least 1")
+	}
+	s.slots = make(chan struct{}, s.cfg.MaxConcurrent)
+
+	if s.cfg.BlockstoreDir != "" {
+		var err error
+		if s.blockstore, err = blockstore.Open(s.cfg.BlockstoreDir); err != nil {
+			return err
+		}
+	}
+
+	// validate the server-wide configuration upfront
+	if s.cfg.ConfigFile != "" {
+		anl, errs := NewAnelaceFromArgvWithWriters(s.baseArgv(), ioutil.Discard, ioutil.Discard)
+		if len(errs) == 0 && anl.cfg.AsyncHashers > s.cfg.MaxAsyncHashers {
+			errs = append(errs, fmt.Errorf("async-hashers may not exceed the --max-async-hashers of %d", s.cfg.MaxAsyncHashers))
+		}
+		anl.Destroy()
+		if len(errs) > 0 {
+			return fmt.Errorf("invalid --config '%s':\n\t%s", s.cfg.ConfigFile, strings.Join(getErrrStrings(errs), "\n\t"))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", s.handleIngest)
+
+	log.Printf("anelace serving on http://%s", s.cfg.Listen)
+	return http.ListenAndServe(s.cfg.Listen, mux)
+}
+
+func (s *server) baseArgv() []string {
+	argv := []string{"anelace-serve"}
+	if s.cfg.ConfigFile != "" {
+		argv = append(argv, "--config="+s.cfg.ConfigFile)
+	}
+	return argv
+}
+
+func (s *server) requestArgv(r *http.Request) (argv []string, err error) {
+
+	argv = s.baseArgv()
+
+	q := r.URL.Query()
+	names := make([]string, 0, len(q))
+	for n := range q {
+		if n != "car" && n != "blockstore" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if !serveOverridable[n] {
+			return nil, fmt.Errorf("option '%s' can not be set per-request", n)
+		}
+		for _, v := range q[n] {
+			// checked before anything is instantiated: the hashing pool is
+			// sized by this value
+			if n == "async-hashers" {
+				if h, err := strconv.Atoi(v); err != nil || h < 0 || h > s.cfg.MaxAsyncHashers {
+					return nil, fmt.Errorf("async-hashers must be an integer between 0 and %d", s.cfg.MaxAsyncHashers)
+				}
+			}
+			for _, sub := range strings.Split(v, "_")[1:] {
+				subName := strings.SplitN(sub, "=", 2)[0]
+				if strings.HasSuffix(subName, "-file") || strings.HasSuffix(subName, "-env") {
+					return nil, fmt.Errorf("sub-option '%s' of '%s' can not be set per-request", subName, n)
+				}
+			}
+			argv = append(argv, "--"+n+"="+v)
+		}
+	}
+
+	return argv, nil
+}
+
+func boolParam(r *http.Request, name string) bool {
+	switch strings.ToLower(r.URL.Query().Get(name)) {
+	case "1", "t", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
+func httpErrorJSON(w http.ResponseWriter, code int, errs ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct { //nolint:errcheck
+		Errors []string `json:"errors"`
+	}{errs})
+}
+
+func (s *server) handleIngest(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpErrorJSON(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+
+	wantCar := boolParam(r, "car")
+	wantBlockstore := boolParam(r, "blockstore")
+	if wantBlockstore && s.blockstore == nil {
+		httpErrorJSON(w, http.StatusBadRequest, "server started without --blockstore-dir")
+		return
+	}
+	if wantCar && wantBlockstore {
+		httpErrorJSON(w, http.StatusBadRequest, "'car' and 'blockstore' are mutually exclusive")
+		return
+	}
+	if wantCar && s.cfg.MaxRequestSize <= 0 {
+		// car responses spool the entire body to disk, which must stay bounded
+		httpErrorJSON(w, http.StatusBadRequest, "server started without a --max-request-size, car responses are unavailable")
+		return
+	}
+
+	argv, err := s.requestArgv(r)
+	if err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var summary bytes.Buffer
+	var stdout, stderr io.Writer
+	var bsPipeR *io.PipeReader
+	var bsPipeW *io.PipeWriter
+
+	switch {
+	case wantCar:
+		argv = append(argv, "--emit-stdout=car-v1-stream", "--emit-stderr=stats-jsonl")
+		stdout, stderr = w, &summary
+	case wantBlockstore:
+		bsPipeR, bsPipeW = io.Pipe()
+		argv = append(argv, "--emit-stdout=stats-jsonl", "--emit-stderr=car-v1-stream")
+		stdout, stderr = &summary, bsPipeW
+	default:
+		argv = append(argv, "--emit-stdout=stats-jsonl", "--emit-stderr=none")
+		stdout, stderr = &summary, ioutil.Discard
+	}
+
+	anl, argErrs := NewAnelaceFromArgvWithWriters(argv, stderr, stdout)
+	defer anl.Destroy()
+	if len(argErrs) > 0 {
+		httpErrorJSON(w, http.StatusBadRequest, getErrrStrings(argErrs)...)
+		return
+	}
+
+	// wait for a free slot, unless the client goes away first
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-r.Context().Done():
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if s.cfg.MaxRequestSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.cfg.MaxRequestSize)
+	}
+
+	var bsErr chan error
+	if wantBlockstore {
+		bsErr = make(chan error, 1)
+		go func() {
+			err := s.storeCarStream(bsPipeR)
+			bsPipeR.CloseWithError(err) //nolint:errcheck
+			bsErr <- err
+		}()
+	}
+
+	if wantCar {
+		// an HTTP/1.x server can not read the request once the response
+		// started: take in the entire body before the first CAR byte goes out
+		spool, err := ioutil.TempFile("", "anelace-serve-")
+		if err != nil {
+			httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer os.Remove(spool.Name()) //nolint:errcheck
+		defer spool.Close()           //nolint:errcheck
+
+		if _, err := io.Copy(spool, body); err != nil {
+			httpErrorJSON(w, bodyErrorStatus(err, http.StatusBadRequest), fmt.Sprintf("failed reading request body: %s", err))
+			return
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		body = spool
+
+		w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		w.Header().Set("Trailer", "X-Anelace-Summary, X-Anelace-Error")
+	}
+
+	err = anl.ProcessReader(body, nil)
+
+	if wantBlockstore {
+		bsPipeW.Close() //nolint:errcheck
+		if bsErrVal := <-bsErr; err == nil && bsErrVal != nil {
+			err = fmt.Errorf("blockstore write failed: %s", bsErrVal)
+		}
+	}
+
+	if err == nil {
+		anl.OutputSummary()
+	}
+
+	if wantCar {
+		// the status code is long gone: all we can do is signal via the trailer
+		if err != nil {
+			w.Header().Set("X-Anelace-Error", err.Error())
+		} else {
+			w.Header().Set("X-Anelace-Summary", strings.TrimSpace(summary.String()))
+		}
+		return
+	}
+
+	if err != nil {
+		httpErrorJSON(w, bodyErrorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(summary.Bytes()) //nolint:errcheck
+}
+
+// the MaxBytesReader error arrives wrapped in the ingestion error string
+func bodyErrorStatus(err error, fallback int) int {
+	if strings.Contains(err.Error(), "http: request body too large") {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
+func (s *server) storeCarStream(r io.Reader) error {
+	cr := car.NewReader(r)
+	for {
+		cid, data, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.blockstore.Put(cid, data); err != nil {
+			return err
+		}
+	}
+}
+
+// used by the CLI to decide whether argv is a subcommand
+var subCommands = map[string]func([]string) error{
+	"serve":   Serve,
+	"gateway": Gateway,
+	"rechunk": Rechunk,
+}
+
+// RunSubCommand executes argv[1] as a subcommand, if it is one. Returns false
+// when argv does not denote a subcommand.
+func RunSubCommand(argv []string) (bool, error) {
+	if len(argv) < 2 {
+		return false, nil
+	}
+	if cmd, exists := subCommands[argv[1]]; exists {
+		return true, cmd(argv[1:])
+	}
+	return false, nil
+}
@@ -0,0 +1,71 @@
+package anelace
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/anjor/anelace/internal/util/blockstore"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServeIngest(t *testing.T) {
+
+	data := testData(testRand(0), 1024*1024)
+
+	dir, err := ioutil.TempDir("", "anelace-serve-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &server{cfg: serveConfig{MaxAsyncHashers: 1}, slots: make(chan struct{}, 1)}
+	if s.blockstore, err = blockstore.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ingest := func(query string, body []byte) (int, statSummary) {
+		rec := httptest.NewRecorder()
+		s.handleIngest(rec, httptest.NewRequest(http.MethodPost, "/ingest?"+query, bytes.NewReader(body)))
+		var smr statSummary
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &smr); err != nil {
+				t.Fatalf("unparseable response: %s", err)
+			}
+		}
+		return rec.Code, smr
+	}
+
+	code, smr := ingest("preset=kubo-default&blockstore=1", data)
+	if code != http.StatusOK || len(smr.Roots) != 1 {
+		t.Fatalf("unexpected ingestion result %d %v", code, smr.Roots)
+	}
+
+	cliRoot := testIngest(t, bytes.NewReader(data), "--emit-stdout=none", "--emit-stderr=none", "--preset=kubo-default").roots()[0]
+	if cliRoot.CidString != smr.Roots[0].Cid {
+		t.Errorf("served root %s differs from CLI root %s", smr.Roots[0].Cid, cliRoot.CidString)
+	}
+
+	if stored, _ := ioutil.ReadDir(dir); int64(len(stored)) != smr.Dag.Nodes {
+		t.Errorf("blockstore holds %d blocks, expected %d", len(stored), smr.Dag.Nodes)
+	}
+
+	// without a --max-request-size car responses would spool without bound,
+	// and a huge async-hashers must be refused before the pool is allocated
+	for _, q := range []string{"car=1", "emit-stdout=car-v1-stream", "async-hashers=2", "async-hashers=100000000", "async-hashers=-1", "chunker=fixed-size_key-file=/etc/passwd"} {
+		if code, _ := ingest(q, data); code != http.StatusBadRequest {
+			t.Errorf("query '%s' returned %d instead of %d", q, code, http.StatusBadRequest)
+		}
+	}
+
+	s.cfg.MaxRequestSize = int64(len(data))
+	for size, expected := range map[int]int{len(data): http.StatusOK, len(data) + 1: http.StatusRequestEntityTooLarge} {
+		rec := httptest.NewRecorder()
+		s.handleIngest(rec, httptest.NewRequest(http.MethodPost, "/ingest?car=1", bytes.NewReader(append(data, 0)[:size])))
+		if rec.Code != expected {
+			t.Errorf("car response for a %d byte body returned %d instead of %d", size, rec.Code, expected)
+		}
+	}
+}
@@ -71,9 +71,10 @@ type sysStats struct {
 	GoMaxProcs int    `json:"goMaxProcs"`
 	Os         string `json:"os"`
 
-	ArgvExpanded []string `json:"argvExpanded"`
-	ArgvInitial  []string `json:"argvInitial"`
-	GoVersion    string   `json:"goVersion"`
+	ArgvExpanded     []string `json:"argvExpanded"`
+	ArgvInitial      []string `json:"argvInitial"`
+	GoVersion        string   `json:"goVersion"`
+	CidCompatVersion int      `json:"cidCompatVersion"`
 }
 
 type statSummary struct {
@@ -83,20 +84,35 @@ type statSummary struct {
 		Size    int64 `json:"wireSize"`
 		Payload int64 `json:"payload"`
 	} `json:"logicalDag"`
+	KeyManifests *keyManifestStats `json:"keyManifests,omitempty"` // only with a leaf transform
+	UniqueLeaves struct {
+		Count       int64 `json:"count"`
+		Size        int64 `json:"wireSize"`
+		SizePayload int64 `json:"payload"`
+	} `json:"uniqueLeaves"`
 	Streams  int64       `json:"subStreams"`
 	Roots    []rootStats `json:"roots,omitempty"`
 	SysStats sysStats    `json:"sys"`
 }
+type keyManifestStats struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"wireSize"`
+}
 type rootStats struct {
 	Cid         string `json:"cid"`
+	Path        string `json:"path,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Meta        []byte `json:"meta,omitempty"`
 	SizeDag     uint64 `json:"wireSize"`
 	SizePayload uint64 `json:"payload"`
 	Dup         bool   `json:"duplicate,omitempty"`
 }
 
 type uniqueBlockStats struct {
-	sizeBlock int
-	isData    bool
+	sizeBlock     int
+	sizePayload   int // for data blocks: differs from sizeBlock when the leaf is wrapped or compressed
+	isData        bool
+	isKeyManifest bool
 }
 
 func (anl *Anelace) OutputSummary() {
@@ -107,7 +123,7 @@ func (anl *Anelace) OutputSummary() {
 	}
 
 	smr := &anl.statSummary
-	var totalUCount, totalUWeight, leafUWeight, leafUCount int64
+	var totalUCount, totalUWeight, leafUWeight, leafUCount, leafUPayload, keyUWeight, keyUCount int64
 
 	if anl.seenBlocks != nil && len(anl.seenBlocks) > 0 {
 		for _, b := range anl.seenBlocks {
@@ -116,10 +132,18 @@ func (anl *Anelace) OutputSummary() {
 			if b.isData {
 				leafUCount++
 				leafUWeight += int64(b.sizeBlock)
+				leafUPayload += int64(b.sizePayload)
+			} else if b.isKeyManifest {
+				keyUCount++
+				keyUWeight += int64(b.sizeBlock)
 			}
 		}
 	}
 
+	smr.UniqueLeaves.Count = leafUCount
+	smr.UniqueLeaves.Size = leafUWeight
+	smr.UniqueLeaves.SizePayload = leafUPayload
+
 	if statsJsonlOut := anl.cfg.emitters[emStatsJsonl]; statsJsonlOut != nil {
 		// emit the JSON last, so that piping to e.g. `jq` works nicer
 		defer func() {
@@ -203,11 +227,28 @@ func (anl *Anelace) OutputSummary() {
 		text.Commify64(leafUWeight), text.Commify64(leafUCount),
 	))
 
+	// only happens with leaf compression
+	if leafUWeight < leafUPayload {
+		descParts = append(descParts, fmt.Sprintf(
+			"Compressed down from:%17s bytes of unique leaf payload, %.02f%% saved\n",
+			text.Commify64(leafUPayload),
+			100*float64(leafUPayload-leafUWeight)/float64(leafUPayload),
+		))
+	}
+
 	if anl.cfg.requestedCollector != "none" {
 		descParts = append(descParts, fmt.Sprintf(
-			"Linked as streams by:%17s bytes over %s unique DAG-PB nodes\n"+
-				"Taking a grand-total:%17s bytes, ",
-			text.Commify64(totalUWeight-leafUWeight), text.Commify64(totalUCount-leafUCount),
+			"Linked as streams by:%17s bytes over %s unique DAG-PB nodes\n",
+			text.Commify64(totalUWeight-leafUWeight-keyUWeight), text.Commify64(totalUCount-leafUCount-keyUCount),
+		))
+		if keyUCount > 0 {
+			descParts = append(descParts, fmt.Sprintf(
+				"Keyed for access by:%18s bytes over %s unique key manifests\n",
+				text.Commify64(keyUWeight), text.Commify64(keyUCount),
+			))
+		}
+		descParts = append(descParts, fmt.Sprintf(
+			"Taking a grand-total:%17s bytes, ",
 			text.Commify64(totalUWeight),
 		))
 	} else {